@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Access kinds checked against key ACLs
+const (
+	AccessRead  = "read"
+	AccessWrite = "write"
+)
+
+// ACLRule grants read and write access to keys under a prefix. Entries are
+// principal IDs ("key:<id>" for API keys, "jwt:<subject>" for JWT subjects),
+// "role:<name>" for JWT roles, or "*" for everyone including unauthenticated
+// requests.
+type ACLRule struct {
+	Prefix string   `json:"prefix"`
+	Read   []string `json:"read"`
+	Write  []string `json:"write"`
+}
+
+// aclRegistry holds the key ACL rules by prefix
+type aclRegistry struct {
+	mu    sync.RWMutex
+	rules map[string]ACLRule
+}
+
+var acls = &aclRegistry{rules: make(map[string]ACLRule)}
+
+// set adds or replaces the rule for its prefix
+func (a *aclRegistry) set(rule ACLRule) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules[rule.Prefix] = rule
+}
+
+// replace swaps in rules as the full set
+func (a *aclRegistry) replace(rules []ACLRule) {
+	byPrefix := make(map[string]ACLRule, len(rules))
+	for _, rule := range rules {
+		byPrefix[rule.Prefix] = rule
+	}
+	a.mu.Lock()
+	a.rules = byPrefix
+	a.mu.Unlock()
+}
+
+// remove deletes the rule for prefix, reporting whether one existed
+func (a *aclRegistry) remove(prefix string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.rules[prefix]
+	delete(a.rules, prefix)
+	return ok
+}
+
+// list returns every rule ordered by prefix
+func (a *aclRegistry) list() []ACLRule {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	rules := make([]ACLRule, 0, len(a.rules))
+	for _, rule := range a.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Prefix < rules[j].Prefix })
+	return rules
+}
+
+// allows reports whether p may access key; the rule with the longest
+// matching prefix decides, and keys without a rule are open
+func (a *aclRegistry) allows(p *principal, key, access string) bool {
+	a.mu.RLock()
+	var rule *ACLRule
+	longest := -1
+	for prefix, r := range a.rules {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			r := r
+			rule, longest = &r, len(prefix)
+		}
+	}
+	a.mu.RUnlock()
+
+	if rule == nil {
+		return true
+	}
+	entries := rule.Read
+	if access == AccessWrite {
+		entries = rule.Write
+	}
+	return p.matchesAny(entries)
+}
+
+// matchesAny reports whether any of entries names p, in the syntax of ACL
+// entries: its ID, "role:<name>" for one of its roles, or "*". Only "*"
+// matches a nil principal.
+func (p *principal) matchesAny(entries []string) bool {
+	for _, e := range entries {
+		if e == "*" {
+			return true
+		}
+		if p == nil {
+			continue
+		}
+		if e == p.ID {
+			return true
+		}
+		for _, role := range p.Roles {
+			if e == "role:"+role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleListACL handles the HTTP GET request listing the key ACL rules
+func handleListACL(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(acls.list())
+}
+
+// handleSetACL handles the HTTP POST request to add or replace a key ACL rule
+func handleSetACL(w http.ResponseWriter, r *http.Request) {
+	var rule ACLRule
+	err := json.NewDecoder(r.Body).Decode(&rule)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !canonicalizePrefixes(w, &rule.Prefix) {
+		return
+	}
+	acls.set(rule)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleRemoveACL handles the HTTP DELETE request to remove the key ACL rule of a prefix
+func handleRemoveACL(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if !canonicalizePrefixes(w, &prefix) {
+		return
+	}
+	if !acls.remove(prefix) {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
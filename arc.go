@@ -0,0 +1,188 @@
+package main
+
+import "container/list"
+
+// ARC lists an entry can be on
+const (
+	arcT1 = iota // Resident, seen once recently
+	arcT2        // Resident, seen at least twice
+	arcB1        // Ghost of an entry evicted from T1
+	arcB2        // Ghost of an entry evicted from T2
+)
+
+// arcPolicy implements the Adaptive Replacement Cache. Resident entries are
+// split between T1 (recency) and T2 (frequency), and the ghost lists B1 and
+// B2 remember keys recently evicted from each. A miss that hits a ghost list
+// shifts the target size p of T1 towards the list that would have kept it.
+type arcPolicy struct {
+	capacity int
+	p        float64 // Target size of T1
+
+	entries map[string]*arcEntry
+	lists   [4]*list.List // Indexed by arcT1..arcB2, most recent at the front
+
+	inserts      uint64
+	b1Hits       uint64
+	b2Hits       uint64
+	lastFromB2   bool // Whether the last insert was a B2 ghost hit
+	lastInserted string
+}
+
+type arcEntry struct {
+	key  string
+	list int
+	ele  *list.Element
+}
+
+// ARCStats reports the adaptive parameter and list sizes of the ARC policy
+type ARCStats struct {
+	P         float64 `json:"p"`
+	T1        int     `json:"t1"`
+	T2        int     `json:"t2"`
+	B1        int     `json:"b1"`
+	B2        int     `json:"b2"`
+	B1Hits    uint64  `json:"b1_hits"`
+	B2Hits    uint64  `json:"b2_hits"`
+	B1HitRate float64 `json:"b1_hit_rate"` // Share of inserts that hit B1, favouring recency
+	B2HitRate float64 `json:"b2_hit_rate"` // Share of inserts that hit B2, favouring frequency
+}
+
+func newARCPolicy(capacity int) *arcPolicy {
+	p := &arcPolicy{capacity: capacity, entries: make(map[string]*arcEntry)}
+	for i := range p.lists {
+		p.lists[i] = list.New()
+	}
+	return p
+}
+
+func (p *arcPolicy) name() string { return "arc" }
+
+func (p *arcPolicy) added(item *CacheItem) {
+	p.inserts++
+	p.lastInserted = item.Key
+	p.lastFromB2 = false
+
+	e, ok := p.entries[item.Key]
+	if !ok {
+		e = &arcEntry{key: item.Key, list: arcT1}
+		p.entries[item.Key] = e
+		e.ele = p.lists[arcT1].PushFront(e)
+		return
+	}
+
+	b1, b2 := float64(p.lists[arcB1].Len()), float64(p.lists[arcB2].Len())
+	switch e.list {
+	case arcB1:
+		p.b1Hits++
+		p.p = min(p.p+max(b2/b1, 1), float64(p.capacity))
+	case arcB2:
+		p.b2Hits++
+		p.lastFromB2 = true
+		p.p = max(p.p-max(b1/b2, 1), 0)
+	}
+	p.move(e, arcT2)
+}
+
+func (p *arcPolicy) accessed(item *CacheItem) {
+	if e, ok := p.entries[item.Key]; ok && (e.list == arcT1 || e.list == arcT2) {
+		p.move(e, arcT2)
+	}
+}
+
+func (p *arcPolicy) removed(item *CacheItem, reason RemovalReason) {
+	e, ok := p.entries[item.Key]
+	if !ok || e.list == arcB1 || e.list == arcB2 {
+		return
+	}
+	if reason != RemovalEvicted {
+		p.lists[e.list].Remove(e.ele)
+		delete(p.entries, item.Key)
+		return
+	}
+
+	if e.list == arcT1 {
+		p.move(e, arcB1)
+	} else {
+		p.move(e, arcB2)
+	}
+	p.trimGhosts()
+}
+
+// victim implements ARC's REPLACE: evict from T1 while it exceeds its
+// target p, otherwise from T2
+func (p *arcPolicy) victim(incoming string) string {
+	t1 := p.lists[arcT1].Len()
+	if incoming != "" && incoming == p.lastInserted {
+		if e, ok := p.entries[incoming]; ok && e.list == arcT1 {
+			t1-- // REPLACE runs before the new entry is placed
+		}
+	}
+
+	fromT1 := t1 >= 1 && (float64(t1) > p.p || (p.lastFromB2 && float64(t1) == p.p))
+	if !fromT1 && p.lists[arcT2].Len() == 0 {
+		fromT1 = true
+	}
+	l := p.lists[arcT2]
+	if fromT1 {
+		l = p.lists[arcT1]
+	}
+	for ele := l.Back(); ele != nil; ele = ele.Prev() {
+		if key := ele.Value.(*arcEntry).key; key != incoming {
+			return key
+		}
+	}
+	return incoming
+}
+
+func (p *arcPolicy) resize(capacity int) {
+	p.capacity = capacity
+	p.p = min(p.p, float64(capacity))
+	p.trimGhosts()
+}
+
+func (p *arcPolicy) stats() interface{} {
+	st := ARCStats{
+		P:      p.p,
+		T1:     p.lists[arcT1].Len(),
+		T2:     p.lists[arcT2].Len(),
+		B1:     p.lists[arcB1].Len(),
+		B2:     p.lists[arcB2].Len(),
+		B1Hits: p.b1Hits,
+		B2Hits: p.b2Hits,
+	}
+	if p.inserts > 0 {
+		st.B1HitRate = float64(p.b1Hits) / float64(p.inserts)
+		st.B2HitRate = float64(p.b2Hits) / float64(p.inserts)
+	}
+	return st
+}
+
+func (p *arcPolicy) needsOrder() bool { return true }
+
+// move puts e at the front of list l
+func (p *arcPolicy) move(e *arcEntry, l int) {
+	p.lists[e.list].Remove(e.ele)
+	e.list = l
+	e.ele = p.lists[l].PushFront(e)
+}
+
+// trimGhosts keeps |T1|+|B1| and the whole directory within ARC's bounds of
+// c and 2c entries
+func (p *arcPolicy) trimGhosts() {
+	for p.lists[arcB1].Len() > 0 && p.lists[arcT1].Len()+p.lists[arcB1].Len() > p.capacity {
+		p.dropGhost(arcB1)
+	}
+	for p.lists[arcB2].Len() > 0 && len(p.entries) > 2*p.capacity {
+		p.dropGhost(arcB2)
+	}
+	for p.lists[arcB1].Len() > 0 && len(p.entries) > 2*p.capacity {
+		p.dropGhost(arcB1)
+	}
+}
+
+// dropGhost forgets the least recent entry of ghost list l
+func (p *arcPolicy) dropGhost(l int) {
+	e := p.lists[l].Back().Value.(*arcEntry)
+	p.lists[l].Remove(e.ele)
+	delete(p.entries, e.key)
+}
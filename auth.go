@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lrucache/clock"
+
+	"github.com/gorilla/mux"
+)
+
+// Headers carrying an HMAC request signature
+const (
+	headerKeyID     = "X-Signature-Key-Id"
+	headerTimestamp = "X-Signature-Timestamp"
+	headerSignature = "X-Signature"
+)
+
+// Replay protection for signed requests
+const (
+	defaultMaxSkew    = 5 * time.Minute
+	defaultSignedRate = 1000 // Signed requests per second the nonce store holds by default
+	nonceDigestBytes  = 16   // Length of the signature digest remembered per request
+	nonceEntryBytes   = nonceDigestBytes + expiringEntryOverhead
+)
+
+// nonces holds digests of the signatures seen within the allowed skew,
+// outside the cache keyspace so they take no capacity and cannot be read or
+// deleted. It is sized by setAuthConfig.
+var nonces = newExpiringStore(0)
+
+type principalKey struct{}
+
+// Principal ID prefixes, keeping API key IDs and JWT subjects apart so a
+// token cannot take on the grants of a key that shares its name
+const (
+	principalKeyPrefix = "key:"
+	principalJWTPrefix = "jwt:"
+)
+
+// principal is the authenticated identity of a request
+type principal struct {
+	ID       string // "key:<key id>" or "jwt:<subject>"
+	Roles    []string
+	Groups   []string // Route groups the principal may use; nil allows all
+	Prefixes []string // Key prefixes the principal may touch; nil allows all
+}
+
+var (
+	authMu  sync.RWMutex
+	authCfg AuthConfig
+)
+
+// authClock is checked against signature timestamps and token time claims
+var authClock clock.Clock = clock.Real{}
+
+// setAuthConfig installs the authentication settings and sizes the nonce
+// store to hold every signature accepted at the configured rate over the
+// time it stays valid
+func setAuthConfig(cfg AuthConfig) {
+	rate := cfg.MaxSignedRate
+	if rate <= 0 {
+		rate = defaultSignedRate
+	}
+	window := int(2 * maxSkew(cfg) / time.Second)
+	nonces.setMaxBytes(rate * window * nonceEntryBytes)
+
+	authMu.Lock()
+	authCfg = cfg
+	authMu.Unlock()
+}
+
+// maxSkew returns how far a signature timestamp may be from the server clock
+func maxSkew(cfg AuthConfig) time.Duration {
+	if cfg.MaxSkewSeconds <= 0 {
+		return defaultMaxSkew
+	}
+	return time.Duration(cfg.MaxSkewSeconds) * time.Second
+}
+
+// principalFrom returns the authenticated identity of a request, if any
+func principalFrom(r *http.Request) (*principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(*principal)
+	return p, ok
+}
+
+// keyAllowed reports whether the request's principal may access key with
+// the given access, checking both its token prefixes and the key ACLs.
+// Unauthenticated requests are only possible with auth disabled.
+func keyAllowed(r *http.Request, key, access string) bool {
+	p, ok := principalFrom(r)
+	if !acls.allows(p, key, access) {
+		return false
+	}
+	if !ok || p.Prefixes == nil {
+		return true
+	}
+	for _, prefix := range p.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsGroup reports whether the principal may use a route group
+func (p *principal) allowsGroup(group string) bool {
+	if p.Groups == nil {
+		return true
+	}
+	for _, g := range p.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// signRequest computes the signature of a request: an HMAC-SHA256 over the
+// method, request URI, timestamp and body, each separated by a newline
+func signRequest(secret []byte, method, uri, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, method+"\n"+uri+"\n"+timestamp+"\n")
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authenticate returns a middleware that, when auth is enabled, requires a
+// valid bearer JWT or HMAC signature and checks the principal may use group
+func authenticate(group string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authMu.RLock()
+			cfg := authCfg
+			authMu.RUnlock()
+			if !cfg.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var p *principal
+			var status int
+			var msg string
+			authz := r.Header.Get("Authorization")
+			if strings.HasPrefix(authz, "Bearer ") && cfg.JWT.JWKSURL != "" {
+				p, status, msg = verifyBearer(strings.TrimPrefix(authz, "Bearer "), cfg.JWT)
+			} else {
+				p, status, msg = verifyHMAC(r, cfg)
+			}
+			if p == nil {
+				http.Error(w, msg, status)
+				return
+			}
+			if !p.allowsGroup(group) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+		})
+	}
+}
+
+// verifyBearer authenticates a JWT, mapping its claims to route groups and key prefixes
+func verifyBearer(token string, cfg JWTConfig) (*principal, int, string) {
+	claims, err := verifyJWT(token, cfg)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "Invalid token: " + err.Error()
+	}
+
+	groupsClaim, prefixesClaim := cfg.GroupsClaim, cfg.PrefixesClaim
+	if groupsClaim == "" {
+		groupsClaim = "cache_ops"
+	}
+	if prefixesClaim == "" {
+		prefixesClaim = "cache_prefixes"
+	}
+
+	rolesClaim := cfg.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+
+	sub, _ := claims["sub"].(string)
+	p := &principal{ID: principalJWTPrefix + sub, Roles: claimStrings(claims[rolesClaim]), Groups: claimStrings(claims[groupsClaim])}
+	if p.Groups == nil {
+		// tokens without the claim get no access rather than full access
+		p.Groups = []string{}
+	}
+	if v, ok := claims[prefixesClaim]; ok {
+		p.Prefixes = claimStrings(v)
+	}
+	return p, 0, ""
+}
+
+// verifyHMAC authenticates an HMAC-signed request. The timestamp must be
+// within the allowed skew and each signature may only be used once. The body
+// is buffered to check the signature, so bodies over the cap are refused.
+func verifyHMAC(r *http.Request, cfg AuthConfig) (*principal, int, string) {
+	keyID := r.Header.Get(headerKeyID)
+	secret, ok := cfg.HMACKeys[keyID]
+	if !ok {
+		return nil, http.StatusUnauthorized, "Unauthorized"
+	}
+
+	timestamp := r.Header.Get(headerTimestamp)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	skew := maxSkew(cfg)
+	if err != nil || authClock.Now().Sub(time.Unix(ts, 0)).Abs() > skew {
+		return nil, http.StatusUnauthorized, "Signature timestamp out of range"
+	}
+
+	body, err := bufferBody(r)
+	if err == errBodyTooLarge {
+		return nil, http.StatusRequestEntityTooLarge, "Request body too large to verify"
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, "Invalid request body"
+	}
+
+	expected := signRequest([]byte(secret), r.Method, r.URL.RequestURI(), timestamp, body)
+	signature := r.Header.Get(headerSignature)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, http.StatusUnauthorized, "Invalid signature"
+	}
+
+	// A signature stays valid for the skew on either side of its timestamp
+	digest := sha256.Sum256([]byte(signature))
+	added, err := nonces.add(string(digest[:nonceDigestBytes]), nil, 2*skew)
+	if err != nil {
+		return nil, http.StatusServiceUnavailable, "Too many signed requests, retry later"
+	}
+	if !added {
+		return nil, http.StatusUnauthorized, "Replayed request"
+	}
+	return &principal{ID: principalKeyPrefix + keyID}, 0, ""
+}
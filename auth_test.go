@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"lrucache/clock"
+	"lrucache/clocktest"
+)
+
+// useAuthClock installs a fake auth clock and the auth config for a test
+func useAuthClock(t *testing.T, cfg AuthConfig) *clocktest.Fake {
+	t.Helper()
+	clk := clocktest.NewFake(time.Now())
+	authClock = clk
+	setAuthConfig(cfg)
+	if cache == nil {
+		cache = NewLRUCache(100)
+	}
+	t.Cleanup(func() {
+		authClock = clock.Real{}
+		setAuthConfig(AuthConfig{})
+	})
+	return clk
+}
+
+// signedRequest builds a request signed with secret under keyID at ts
+func signedRequest(keyID, secret string, ts time.Time, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPut, "/cache/k?ttl=60", strings.NewReader(body))
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	r.Header.Set(headerKeyID, keyID)
+	r.Header.Set(headerTimestamp, timestamp)
+	r.Header.Set(headerSignature, signRequest([]byte(secret), r.Method, r.URL.RequestURI(), timestamp, []byte(body)))
+	return r
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	cfg := AuthConfig{Enabled: true, HMACKeys: map[string]string{"app": "s3cret"}, MaxSkewSeconds: 60}
+	clk := useAuthClock(t, cfg)
+	now := clk.Now()
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"unknown key", signedRequest("other", "s3cret", now, "v"), http.StatusUnauthorized},
+		{"wrong secret", signedRequest("app", "guess", now, "v"), http.StatusUnauthorized},
+		{"too old", signedRequest("app", "s3cret", now.Add(-61*time.Second), "v"), http.StatusUnauthorized},
+		{"too far ahead", signedRequest("app", "s3cret", now.Add(61*time.Second), "v"), http.StatusUnauthorized},
+		{"within skew", signedRequest("app", "s3cret", now.Add(-59*time.Second), "v"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, status, msg := verifyHMAC(tt.req, cfg)
+			if status != tt.status {
+				t.Fatalf("status = %d (%s), want %d", status, msg, tt.status)
+			}
+			if tt.status == 0 && (p == nil || p.ID != "key:app") {
+				t.Errorf("principal = %+v, want key:app", p)
+			}
+		})
+	}
+
+	t.Run("tampered body", func(t *testing.T) {
+		r := signedRequest("app", "s3cret", now, "v")
+		r.Body = http.NoBody
+		if _, status, _ := verifyHMAC(r, cfg); status != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", status)
+		}
+	})
+
+	t.Run("bad timestamp", func(t *testing.T) {
+		r := signedRequest("app", "s3cret", now, "v")
+		r.Header.Set(headerTimestamp, "yesterday")
+		if _, status, _ := verifyHMAC(r, cfg); status != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", status)
+		}
+	})
+
+	t.Run("body buffered for the handler", func(t *testing.T) {
+		r := signedRequest("app", "s3cret", now, "payload")
+		if _, status, msg := verifyHMAC(r, cfg); status != 0 {
+			t.Fatalf("status = %d (%s)", status, msg)
+		}
+		body := make([]byte, 16)
+		n, _ := r.Body.Read(body)
+		if string(body[:n]) != "payload" {
+			t.Errorf("body = %q after verification, want payload", body[:n])
+		}
+	})
+}
+
+func TestVerifyHMACReplay(t *testing.T) {
+	cfg := AuthConfig{Enabled: true, HMACKeys: map[string]string{"app": "s3cret"}, MaxSkewSeconds: 60}
+	clk := useAuthClock(t, cfg)
+	ts := clk.Now()
+
+	if _, status, msg := verifyHMAC(signedRequest("app", "s3cret", ts, "replay"), cfg); status != 0 {
+		t.Fatalf("first use refused: %d %s", status, msg)
+	}
+	clk.Advance(30 * time.Second)
+	if _, status, _ := verifyHMAC(signedRequest("app", "s3cret", ts, "replay"), cfg); status != http.StatusUnauthorized {
+		t.Errorf("replay within the skew: status = %d, want 401", status)
+	}
+	clk.Advance(31 * time.Second)
+	if _, status, _ := verifyHMAC(signedRequest("app", "s3cret", ts, "replay"), cfg); status != http.StatusUnauthorized {
+		t.Errorf("replay once the timestamp is stale: status = %d, want 401", status)
+	}
+	// A fresh signature of the same request is accepted
+	if _, status, msg := verifyHMAC(signedRequest("app", "s3cret", clk.Now(), "replay"), cfg); status != 0 {
+		t.Errorf("new signature refused: %d %s", status, msg)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	cfg := AuthConfig{Enabled: true, HMACKeys: map[string]string{"app": "s3cret"}}
+	clk := useAuthClock(t, cfg)
+
+	var got *principal
+	h := authenticate("reads")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = principalFrom(r)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, signedRequest("app", "s3cret", clk.Now(), ""))
+	if w.Code != http.StatusOK || got == nil || got.ID != "key:app" {
+		t.Errorf("signed request: status %d, principal %+v", w.Code, got)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache/k", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request: status %d, want 401", w.Code)
+	}
+}
+
+// jwtSigner signs test tokens and serves its public keys as a JWKS
+type jwtSigner struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	server *httptest.Server
+}
+
+func newJWTSigner(t *testing.T) *jwtSigner {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &jwtSigner{rsaKey: rsaKey, ecKey: ecKey}
+
+	b64 := func(n *big.Int, size int) string {
+		return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+	}
+	set := map[string]interface{}{"keys": []map[string]string{
+		{"kid": "rsa", "kty": "RSA", "n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)), 3)},
+		{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(ecKey.X, 32), "y": b64(ecKey.Y, 32)},
+	}}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// sign returns a compact JWT of claims signed by the key kid
+func (s *jwtSigner) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	alg := map[string]string{"rsa": "RS256", "ec": "ES256"}[kid]
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch kid {
+	case "rsa":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ec":
+		r, ss, err := ecdsa.Sign(rand.Reader, s.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), ss.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	s := newJWTSigner(t)
+	cfg := JWTConfig{JWKSURL: s.server.URL, Issuer: "https://issuer", Audience: "cache"}
+	clk := useAuthClock(t, AuthConfig{Enabled: true, JWT: cfg})
+	now := clk.Now().Unix()
+
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "iss": "https://issuer", "aud": []string{"other", "cache"}, "exp": now + 60}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		kid   string
+		token func(token string) string
+		claim map[string]interface{}
+		err   string
+	}{
+		{name: "rs256", kid: "rsa"},
+		{name: "es256", kid: "ec"},
+		{name: "expired", kid: "ec", claim: map[string]interface{}{"exp": now}, err: "token expired"},
+		{name: "no expiry", kid: "ec", claim: map[string]interface{}{"exp": nil}, err: "token expired"},
+		{name: "not yet valid", kid: "ec", claim: map[string]interface{}{"nbf": now + 10}, err: "not yet valid"},
+		{name: "wrong issuer", kid: "rsa", claim: map[string]interface{}{"iss": "https://elsewhere"}, err: "unexpected issuer"},
+		{name: "wrong audience", kid: "rsa", claim: map[string]interface{}{"aud": "other"}, err: "unexpected audience"},
+		{name: "tampered claims", kid: "rsa", token: func(tok string) string {
+			parts := strings.Split(tok, ".")
+			payload, _ := json.Marshal(claims(map[string]interface{}{"sub": "admin"}))
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+		}, err: "invalid signature"},
+		{name: "algorithm mismatch", kid: "ec", token: func(tok string) string {
+			parts := strings.Split(tok, ".")
+			header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "ec"})
+			return base64.RawURLEncoding.EncodeToString(header) + "." + parts[1] + "." + parts[2]
+		}, err: "invalid signature"},
+		{name: "malformed", kid: "rsa", token: func(string) string { return "not.a-token" }, err: "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := s.sign(t, tt.kid, claims(tt.claim))
+			if tt.token != nil {
+				token = tt.token(token)
+			}
+			got, err := verifyJWT(token, cfg)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got["sub"] != "alice" {
+					t.Errorf("sub = %v, want alice", got["sub"])
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	t.Run("expires with the clock", func(t *testing.T) {
+		token := s.sign(t, "ec", claims(nil))
+		if _, err := verifyJWT(token, cfg); err != nil {
+			t.Fatal(err)
+		}
+		clk.Advance(time.Minute)
+		if _, err := verifyJWT(token, cfg); err == nil {
+			t.Error("token accepted after its expiry")
+		}
+	})
+}
+
+func TestVerifyBearer(t *testing.T) {
+	s := newJWTSigner(t)
+	cfg := JWTConfig{JWKSURL: s.server.URL}
+	clk := useAuthClock(t, AuthConfig{Enabled: true, JWT: cfg})
+	exp := clk.Now().Unix() + 60
+
+	p, status, msg := verifyBearer(s.sign(t, "rsa", map[string]interface{}{
+		"sub": "alice", "exp": exp, "cache_ops": "reads writes", "cache_prefixes": []string{"a:"},
+	}), cfg)
+	if p == nil {
+		t.Fatalf("status %d: %s", status, msg)
+	}
+	if p.ID != "jwt:alice" || !p.allowsGroup("writes") || p.allowsGroup("admin") {
+		t.Errorf("principal = %+v", p)
+	}
+	if len(p.Prefixes) != 1 || p.Prefixes[0] != "a:" {
+		t.Errorf("prefixes = %v, want [a:]", p.Prefixes)
+	}
+
+	// Without the groups claim a token may use no route group
+	p, _, _ = verifyBearer(s.sign(t, "ec", map[string]interface{}{"sub": "bob", "exp": exp}), cfg)
+	if p == nil || p.allowsGroup("reads") {
+		t.Errorf("token without groups: principal %+v", p)
+	}
+
+	if _, status, _ := verifyBearer("a.b.c", cfg); status != http.StatusUnauthorized {
+		t.Errorf("invalid token: status %d, want 401", status)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// bloomHashes is the number of bit positions set per key
+const bloomHashes = 7
+
+// bloomFilter is a fixed-size bloom filter over string keys
+type bloomFilter struct {
+	bits []uint64
+	n    int // number of keys added
+}
+
+// newBloomFilter creates a filter with room for m bits
+func newBloomFilter(m int) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, (m+63)/64)}
+}
+
+// positions returns the bit positions for key using double hashing
+func (b *bloomFilter) positions(key string) [bloomHashes]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	var pos [bloomHashes]uint64
+	m := uint64(len(b.bits) * 64)
+	for i := range pos {
+		pos[i] = (h1 + uint64(i)*h2) % m
+	}
+	return pos
+}
+
+func (b *bloomFilter) add(key string) {
+	for _, p := range b.positions(key) {
+		b.bits[p/64] |= 1 << (p % 64)
+	}
+	b.n++
+}
+
+func (b *bloomFilter) contains(key string) bool {
+	for _, p := range b.positions(key) {
+		if b.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// evictionFilter remembers recently evicted keys in two rotating bloom
+// filters, so membership covers roughly the last one to two generations
+type evictionFilter struct {
+	mu       sync.Mutex
+	perGen   int
+	current  *bloomFilter
+	previous *bloomFilter
+}
+
+// newEvictionFilter creates a filter rotating every perGen evictions
+func newEvictionFilter(perGen int) *evictionFilter {
+	if perGen < 1 {
+		perGen = 1
+	}
+	return &evictionFilter{
+		perGen:   perGen,
+		current:  newBloomFilter(perGen * 10),
+		previous: newBloomFilter(perGen * 10),
+	}
+}
+
+// add records that key was evicted
+func (f *evictionFilter) add(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.current.n >= f.perGen {
+		f.previous = f.current
+		f.current = newBloomFilter(f.perGen * 10)
+	}
+	f.current.add(key)
+}
+
+// contains reports whether key was probably evicted recently
+func (f *evictionFilter) contains(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current.contains(key) || f.previous.contains(key)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// chaosMiddleware injects latency, server errors and dropped connections
+// with the configured probabilities, for testing client retry logic
+func chaosMiddleware(cfg ChaosConfig) func(http.Handler) http.Handler {
+	logrus.Warnf("chaos mode enabled: latency %dms@%.2f, errors@%.2f, drops@%.2f",
+		cfg.LatencyMs, cfg.LatencyProbability, cfg.ErrorProbability, cfg.DropProbability)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rand.Float64() < cfg.LatencyProbability {
+				time.Sleep(time.Duration(cfg.LatencyMs) * time.Millisecond)
+			}
+			if rand.Float64() < cfg.DropProbability {
+				if hj, ok := w.(http.Hijacker); ok {
+					if conn, _, err := hj.Hijack(); err == nil {
+						conn.Close()
+						return
+					}
+				}
+			}
+			if rand.Float64() < cfg.ErrorProbability {
+				http.Error(w, "Injected failure", http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
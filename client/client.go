@@ -0,0 +1,241 @@
+// Package client is a Go client for the LRU cache HTTP API that spreads keys
+// across a set of cache nodes by hashing them on the client side.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoNodes is returned when the client has no nodes to talk to
+var ErrNoNodes = errors.New("client: no nodes configured")
+
+// Client talks to one or more cache nodes over HTTP
+type Client struct {
+	nodes      []string
+	httpClient *http.Client
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used for requests
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// New creates a Client for the given node base URLs (e.g. "http://10.0.0.1:8080")
+func New(nodes []string, opts ...Option) *Client {
+	c := &Client{httpClient: &http.Client{Timeout: 5 * time.Second}}
+	for _, n := range nodes {
+		c.nodes = append(c.nodes, strings.TrimRight(n, "/"))
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Get retrieves the value for key; the bool reports whether the key was found
+func (c *Client) Get(ctx context.Context, key string) (string, bool, error) {
+	var value string
+	var found bool
+	err := c.do(ctx, key, func(node string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, node+"/get?key="+url.QueryEscape(key), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			found = false
+			return nil
+		case resp.StatusCode != http.StatusOK:
+			return statusError(resp)
+		}
+
+		var body struct {
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return err
+		}
+		value, found = body.Value, true
+		return nil
+	})
+	return value, found, err
+}
+
+// Set stores value under key with the given ttl; a zero ttl uses the
+// server's default TTL and a negative one never expires
+func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"key":   key,
+		"value": value,
+		"exp":   ttl.Seconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, key, func(node string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, node+"/set", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return statusError(resp)
+		}
+		return nil
+	})
+}
+
+// Delete removes key; deleting a missing key is not an error
+func (c *Client) Delete(ctx context.Context, key string) error {
+	payload, err := json.Marshal(map[string]interface{}{"keys": []string{key}})
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, key, func(node string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, node+"/mdel", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return statusError(resp)
+		}
+		return nil
+	})
+}
+
+// DeleteIfEquals removes key only if it holds value, reporting whether it
+// did; false means the key was missing or held another value
+func (c *Client) DeleteIfEquals(ctx context.Context, key, value string) (bool, error) {
+	payload, err := json.Marshal(map[string]string{"key": key, "value": value})
+	if err != nil {
+		return false, err
+	}
+
+	var deleted bool
+	err = c.do(ctx, key, func(node string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, node+"/delete-if-equals", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			deleted = true
+		case http.StatusNotFound, http.StatusConflict:
+			deleted = false
+		default:
+			return statusError(resp)
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// do runs fn against the node owning key, falling back to the next nodes in
+// hash order when a node is unreachable or answers with a server error
+func (c *Client) do(ctx context.Context, key string, fn func(node string) error) error {
+	nodes := c.nodesFor(key)
+	if len(nodes) == 0 {
+		return ErrNoNodes
+	}
+
+	var lastErr error
+	for _, node := range nodes {
+		err := fn(node)
+		if err == nil {
+			return nil
+		}
+		var se *StatusError
+		if errors.As(err, &se) && se.Code < 500 {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// nodesFor returns the nodes ordered by preference for key using rendezvous
+// hashing, so adding or removing a node only moves that node's keys
+func (c *Client) nodesFor(key string) []string {
+	type scored struct {
+		node  string
+		score uint64
+	}
+	ranked := make([]scored, len(c.nodes))
+	for i, n := range c.nodes {
+		h := fnv.New64a()
+		h.Write([]byte(n))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		ranked[i] = scored{node: n, score: h.Sum64()}
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+
+	nodes := make([]string, len(ranked))
+	for i, r := range ranked {
+		nodes[i] = r.node
+	}
+	return nodes
+}
+
+// StatusError is returned when a node answers with an unexpected status code
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("client: server returned %d: %s", e.Code, e.Message)
+}
+
+// statusError builds a StatusError from a response
+func statusError(resp *http.Response) error {
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	return &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(buf.String())}
+}
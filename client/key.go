@@ -0,0 +1,80 @@
+package client
+
+import (
+	"errors"
+	"strings"
+)
+
+// KeySeparator separates the parts of a composite key
+const KeySeparator = ':'
+
+// ErrInvalidKey is returned when parsing a malformed composite key
+var ErrInvalidKey = errors.New("client: invalid composite key")
+
+// Key is a composite key built from parts, e.g. tenant, resource and id.
+// Separators and backslashes inside parts are escaped with a backslash, so
+// Key{"a:b", "c"} and Key{"a", "b:c"} never collide.
+type Key []string
+
+// NewKey builds a composite key from its parts
+func NewKey(parts ...string) Key {
+	return Key(parts)
+}
+
+// With returns a copy of the key with more parts appended
+func (k Key) With(parts ...string) Key {
+	return append(append(Key{}, k...), parts...)
+}
+
+// String encodes the key for use with Get, Set and Delete
+func (k Key) String() string {
+	var b strings.Builder
+	for i, part := range k {
+		if i > 0 {
+			b.WriteByte(KeySeparator)
+		}
+		escapeKeyPart(&b, part)
+	}
+	return b.String()
+}
+
+// Prefix encodes the key as a prefix matching only keys that start with all
+// of its parts, for the server's scan by leading parts
+func (k Key) Prefix() string {
+	if len(k) == 0 {
+		return ""
+	}
+	return k.String() + string(KeySeparator)
+}
+
+// ParseKey splits an encoded composite key back into its parts
+func ParseKey(s string) (Key, error) {
+	var k Key
+	var part strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+1 == len(s) {
+				return nil, ErrInvalidKey
+			}
+			i++
+			part.WriteByte(s[i])
+		case KeySeparator:
+			k = append(k, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	return append(k, part.String()), nil
+}
+
+// escapeKeyPart writes part with its separators and backslashes escaped
+func escapeKeyPart(b *strings.Builder, part string) {
+	for i := 0; i < len(part); i++ {
+		if c := part[i]; c == '\\' || c == KeySeparator {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(part[i])
+	}
+}
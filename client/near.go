@@ -0,0 +1,198 @@
+package client
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NearCache keeps recently fetched values in process and drops them when the
+// server pushes an invalidation for the key over its /events stream
+type NearCache struct {
+	client   *Client
+	capacity int
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	ll    *list.List
+	gen   uint64 // bumped on every invalidation to discard racing fills
+}
+
+type nearItem struct {
+	key   string
+	value string
+	exp   time.Time
+}
+
+// NewNearCache wraps client with a local cache holding up to capacity
+// values, each for at most ttl. Call Run to start consuming invalidations.
+func NewNearCache(client *Client, capacity int, ttl time.Duration) *NearCache {
+	return &NearCache{
+		client:   client,
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		ll:       list.New(),
+	}
+}
+
+// Get returns the locally cached value if present, otherwise fetches it
+func (n *NearCache) Get(ctx context.Context, key string) (string, bool, error) {
+	n.mu.Lock()
+	if ele, ok := n.items[key]; ok {
+		item := ele.Value.(*nearItem)
+		if time.Now().Before(item.exp) {
+			n.ll.MoveToFront(ele)
+			n.mu.Unlock()
+			return item.value, true, nil
+		}
+		n.removeElement(ele)
+	}
+	gen := n.gen
+	n.mu.Unlock()
+
+	value, ok, err := n.client.Get(ctx, key)
+	if err != nil || !ok {
+		return value, ok, err
+	}
+
+	n.mu.Lock()
+	if n.gen == gen {
+		n.store(key, value)
+	}
+	n.mu.Unlock()
+	return value, true, nil
+}
+
+// Set writes through to the server and drops the local copy
+func (n *NearCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	err := n.client.Set(ctx, key, value, ttl)
+	n.Invalidate(key)
+	return err
+}
+
+// Invalidate drops key from the local cache
+func (n *NearCache) Invalidate(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.gen++
+	if ele, ok := n.items[key]; ok {
+		n.removeElement(ele)
+	}
+}
+
+// Purge drops every locally cached value
+func (n *NearCache) Purge() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.gen++
+	n.items = make(map[string]*list.Element)
+	n.ll.Init()
+}
+
+// Run subscribes to every node's invalidation stream until ctx is done.
+// Whenever a stream drops, the local cache is purged since invalidations may
+// have been missed, and the stream is reconnected.
+func (n *NearCache) Run(ctx context.Context) {
+	followEvents(ctx, n.client, n.Invalidate, n.Purge)
+}
+
+// followEvents consumes every node's event stream until ctx is done, calling
+// invalidate for each changed key and purge whenever a stream connects or
+// drops, since invalidations may have been missed in between
+func followEvents(ctx context.Context, c *Client, invalidate func(key string), purge func()) {
+	var wg sync.WaitGroup
+	for _, node := range c.nodes {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			follow(ctx, c, node, invalidate, purge)
+		}(node)
+	}
+	wg.Wait()
+}
+
+// follow consumes one node's event stream, reconnecting with backoff
+func follow(ctx context.Context, c *Client, node string, invalidate func(key string), purge func()) {
+	backoff := 100 * time.Millisecond
+	for {
+		connected := stream(ctx, c, node, invalidate, purge)
+		purge()
+		if connected {
+			backoff = 100 * time.Millisecond
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 5*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// stream reads invalidations from node until the connection ends; it reports
+// whether the stream was established
+func stream(ctx context.Context, c *Client, node string, invalidate func(key string), purge func()) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, node+"/events", nil)
+	if err != nil {
+		return false
+	}
+	// the shared client's timeout would cut the stream off
+	resp, err := (&http.Client{Transport: c.httpClient.Transport}).Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	// anything filled while disconnected may have missed its invalidation
+	purge()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var ev struct {
+			Key string `json:"key"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+			continue
+		}
+		invalidate(ev.Key)
+	}
+	return true
+}
+
+// store adds or replaces key in the local cache, evicting the oldest entry
+func (n *NearCache) store(key, value string) {
+	exp := time.Now().Add(n.ttl)
+	if ele, ok := n.items[key]; ok {
+		n.ll.MoveToFront(ele)
+		item := ele.Value.(*nearItem)
+		item.value = value
+		item.exp = exp
+		return
+	}
+	n.items[key] = n.ll.PushFront(&nearItem{key: key, value: value, exp: exp})
+	if n.ll.Len() > n.capacity {
+		n.removeElement(n.ll.Back())
+	}
+}
+
+// removeElement removes the specified element from the local cache
+func (n *NearCache) removeElement(ele *list.Element) {
+	n.ll.Remove(ele)
+	delete(n.items, ele.Value.(*nearItem).key)
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is one level of a multi-level cache. *Client, *LocalCache and
+// *Tiered all implement it, so tiers can be stacked.
+type Level interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalCache is a small in-process LRU cache with per-entry TTLs, meant as
+// the first level in front of the server
+type LocalCache struct {
+	capacity int
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	ll    *list.List
+}
+
+// NewLocalCache creates an in-process cache holding up to capacity values
+func NewLocalCache(capacity int) *LocalCache {
+	return &LocalCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		ll:       list.New(),
+	}
+}
+
+// Get returns the value for key if present and unexpired
+func (l *LocalCache) Get(_ context.Context, key string) (string, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ele, ok := l.items[key]
+	if !ok {
+		return "", false, nil
+	}
+	item := ele.Value.(*nearItem)
+	if !time.Now().Before(item.exp) {
+		l.removeElement(ele)
+		return "", false, nil
+	}
+	l.ll.MoveToFront(ele)
+	return item.value, true, nil
+}
+
+// Set stores value under key for ttl, evicting the least recently used entry
+func (l *LocalCache) Set(_ context.Context, key, value string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	exp := time.Now().Add(ttl)
+	if ele, ok := l.items[key]; ok {
+		l.ll.MoveToFront(ele)
+		item := ele.Value.(*nearItem)
+		item.value = value
+		item.exp = exp
+		return nil
+	}
+	l.items[key] = l.ll.PushFront(&nearItem{key: key, value: value, exp: exp})
+	if l.ll.Len() > l.capacity {
+		l.removeElement(l.ll.Back())
+	}
+	return nil
+}
+
+// Delete removes key
+func (l *LocalCache) Delete(_ context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ele, ok := l.items[key]; ok {
+		l.removeElement(ele)
+	}
+	return nil
+}
+
+// Purge removes every entry
+func (l *LocalCache) Purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items = make(map[string]*list.Element)
+	l.ll.Init()
+}
+
+// removeElement removes the specified element from the cache
+func (l *LocalCache) removeElement(ele *list.Element) {
+	l.ll.Remove(ele)
+	delete(l.items, ele.Value.(*nearItem).key)
+}
+
+// Tiered is a two-level cache: reads try L1 and fall back to L2, filling L1
+// on the way back; writes and deletes go through to L2 before L1. Typically
+// L1 is a LocalCache and L2 a Client, so processes get in-memory speed while
+// still sharing values through the server.
+type Tiered struct {
+	l1, l2  Level
+	fillTTL time.Duration // Longest time a value is kept in L1
+	gen     atomic.Uint64 // Bumped on every invalidation to discard racing fills
+}
+
+// NewTiered stacks l1 in front of l2. Values are kept in l1 for at most
+// fillTTL, which bounds staleness when invalidations are not followed.
+func NewTiered(l1, l2 Level, fillTTL time.Duration) *Tiered {
+	return &Tiered{l1: l1, l2: l2, fillTTL: fillTTL}
+}
+
+// Get returns the value from L1 if present, otherwise from L2
+func (t *Tiered) Get(ctx context.Context, key string) (string, bool, error) {
+	if value, ok, err := t.l1.Get(ctx, key); err == nil && ok {
+		return value, true, nil
+	}
+
+	gen := t.gen.Load()
+	value, ok, err := t.l2.Get(ctx, key)
+	if err != nil || !ok {
+		return value, ok, err
+	}
+	if t.gen.Load() == gen {
+		t.l1.Set(ctx, key, value, t.fillTTL)
+	}
+	return value, true, nil
+}
+
+// Set writes value to L2 and then to L1. If L2 fails the L1 copy is dropped
+// so the levels do not disagree.
+func (t *Tiered) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := t.l2.Set(ctx, key, value, ttl); err != nil {
+		t.Invalidate(ctx, key)
+		return err
+	}
+	if ttl <= 0 || ttl > t.fillTTL { // Zero and negative mean the server default or no expiry
+		ttl = t.fillTTL
+	}
+	return t.l1.Set(ctx, key, value, ttl)
+}
+
+// Delete removes key from L2 and then from L1
+func (t *Tiered) Delete(ctx context.Context, key string) error {
+	err := t.l2.Delete(ctx, key)
+	t.Invalidate(ctx, key)
+	return err
+}
+
+// Invalidate drops key from L1 only
+func (t *Tiered) Invalidate(ctx context.Context, key string) {
+	t.gen.Add(1)
+	t.l1.Delete(ctx, key)
+}
+
+// Run invalidates L1 from the /events streams of c's nodes until ctx is
+// done, so writes by other processes are seen before fillTTL runs out. If
+// L1 has a Purge method it is purged whenever a stream connects or drops.
+func (t *Tiered) Run(ctx context.Context, c *Client) {
+	purge := func() { t.gen.Add(1) }
+	if p, ok := t.l1.(interface{ Purge() }); ok {
+		purge = func() {
+			t.gen.Add(1)
+			p.Purge()
+		}
+	}
+	followEvents(ctx, c, func(key string) { t.Invalidate(ctx, key) }, purge)
+}
@@ -0,0 +1,128 @@
+// Package clocktest provides a manually advanced clock.Clock for tests.
+package clocktest
+
+import (
+	"sync"
+	"time"
+
+	"lrucache/clock"
+)
+
+// Fake is a clock.Clock whose time only moves when Advance or Set is called
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake creates a Fake clock starting at now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake current time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After returns a channel that receives the fake time once it has advanced by d
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// NewTimer creates a timer that fires once the fake time has advanced by d
+func (f *Fake) NewTimer(d time.Duration) clock.Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	f.schedule(t, d)
+	return t
+}
+
+// Advance moves the fake time forward by d, firing any timers that come due
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setLocked(f.now.Add(d))
+}
+
+// Set moves the fake time to t, firing any timers that come due
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setLocked(t)
+}
+
+// setLocked updates the time and fires due timers; the caller must hold f.mu
+func (f *Fake) setLocked(t time.Time) {
+	f.now = t
+	pending := f.timers[:0]
+	for _, timer := range f.timers {
+		if timer.deadline.After(f.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.active = false
+		select {
+		case timer.c <- f.now:
+		default:
+		}
+	}
+	f.timers = pending
+}
+
+// schedule arms t to fire after d; the caller must hold f.mu
+func (f *Fake) schedule(t *fakeTimer, d time.Duration) {
+	t.deadline = f.now.Add(d)
+	t.active = true
+	if d <= 0 {
+		t.active = false
+		select {
+		case t.c <- f.now:
+		default:
+		}
+		return
+	}
+	f.timers = append(f.timers, t)
+}
+
+// unschedule disarms t, reporting whether it was active; the caller must hold f.mu
+func (f *Fake) unschedule(t *fakeTimer) bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, timer := range f.timers {
+		if timer == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// fakeTimer is a clock.Timer driven by a Fake clock
+type fakeTimer struct {
+	clock    *Fake
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+	return wasActive
+}
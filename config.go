@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/cors"
+	"github.com/sirupsen/logrus"
+)
+
+var configPath string // Path of the config file, re-read on reload
+
+// Config holds the server configuration
+type Config struct {
+	Capacity     int `json:"capacity"`
+	MaxValueSize int `json:"max_value_size"` // Bytes, 0 for unlimited
+	// TTL of writes that do not set one; 0 never expires them
+	DefaultTTLSeconds int                 `json:"default_ttl_seconds"`
+	CORS              CORSConfig          `json:"cors"`
+	Shadow            ShadowConfig        `json:"shadow"`
+	Stats             StatsConfig         `json:"stats"`
+	Contention        ContentionConfig    `json:"contention"`
+	Chaos             ChaosConfig         `json:"chaos"`      // Only used with the -chaos flag
+	Schemas           map[string]*Schema  `json:"schemas"`    // JSON Schemas keyed by key prefix
+	Transforms        map[string][]string `json:"transforms"` // Value transform chains keyed by key prefix
+	// Named AES keys for "aes:<name>" transforms: base64, "env:VAR" or "file:/path"
+	EncryptionKeys map[string]string        `json:"encryption_keys"`
+	Memory         MemoryConfig             `json:"memory"`
+	Admin          AdminConfig              `json:"admin"`
+	Timeouts       map[string]int           `json:"timeouts_ms"` // Per-route deadlines, e.g. {"/get": 50}
+	IPRules        map[string]IPRulesConfig `json:"ip_rules"`    // Keyed by route group: read, write, admin
+	Auth           AuthConfig               `json:"auth"`
+	ACL            []ACLRule                `json:"acl"`
+	Idempotency    IdempotencyConfig        `json:"idempotency"`
+	Eviction       EvictionConfig           `json:"eviction"`
+	ReadOnly       ReadOnlyConfig           `json:"read_only"` // Startup state; toggle at runtime via /admin/readonly
+	OpLog          OpLogConfig              `json:"oplog"`
+	Redaction      RedactionConfig          `json:"redaction"`
+	StatsD         StatsDConfig             `json:"statsd"`
+	Health         HealthConfig             `json:"health"`
+	QoS            QoSConfig                `json:"qos"`
+	Limits         LimitsConfig             `json:"limits"`
+	SlowLog        SlowLogConfig            `json:"slowlog"`
+	Keys           KeysConfig               `json:"keys"`
+	Queries        []QueryDef               `json:"queries"` // Look-aside queries served at /query/{name}
+	Listeners      ListenersConfig          `json:"listeners"`
+}
+
+// CORSConfig holds the cross-origin settings; empty origins allow all
+type CORSConfig struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// ShadowConfig lists the capacities to simulate alongside the live cache,
+// always with LRU eviction
+type ShadowConfig struct {
+	Capacities []int `json:"capacities"`
+}
+
+// StatsConfig lists key glob patterns whose stats are tracked separately and
+// how long per-minute stats history is kept (default 60 minutes)
+type StatsConfig struct {
+	Patterns       []string `json:"patterns"`
+	HistoryMinutes int      `json:"history_minutes"`
+}
+
+// EvictionConfig selects the eviction policy: "lru" (the default), "gdsf",
+// "lirs", "arc" or "sampled", along with the policy's settings
+type EvictionConfig struct {
+	Policy string `json:"policy"`
+	// Entries compared per eviction by sampled LRU (default 5)
+	Samples int `json:"samples"`
+	// Share of capacity for LIRS resident HIR items, in percent (default 1)
+	LIRSHIRPercent float64 `json:"lirs_hir_percent"`
+	// Non-resident LIRS entries remembered, as a multiple of capacity (default 2)
+	LIRSStackFactor float64 `json:"lirs_stack_factor"`
+}
+
+// ContentionConfig enables timing of cache lock waits
+type ContentionConfig struct {
+	Enabled     bool `json:"enabled"`
+	ThresholdMs int  `json:"threshold_ms"`
+}
+
+// ChaosConfig sets the fault injection probabilities, each between 0 and 1
+type ChaosConfig struct {
+	LatencyMs          int     `json:"latency_ms"`
+	LatencyProbability float64 `json:"latency_probability"`
+	ErrorProbability   float64 `json:"error_probability"`
+	DropProbability    float64 `json:"drop_probability"`
+}
+
+// MemoryConfig enables adaptive capacity; a zero TargetBytes disables it.
+// The controller keeps the capacity between MinCapacity and the configured
+// capacity, lowered further by MaxCapacity if set.
+type MemoryConfig struct {
+	TargetBytes     uint64 `json:"target_bytes"`
+	IntervalSeconds int    `json:"interval_seconds"`
+	MinCapacity     int    `json:"min_capacity"`
+	MaxCapacity     int    `json:"max_capacity"`
+	// Writes get 507 while heap/target is at or above this ratio; 0 never rejects
+	RejectAboveRatio float64 `json:"reject_above_ratio"`
+}
+
+// ReadOnlyConfig puts the server in read-only mode, where writes get 503
+// with Retry-After (default 30 seconds) while reads continue
+type ReadOnlyConfig struct {
+	Enabled           bool `json:"enabled"`
+	RetryAfterSeconds int  `json:"retry_after_seconds"`
+}
+
+// OpLogConfig enables recording every cache operation to a file for -replay;
+// an empty path disables it. EncryptionKey names a key in encryption_keys to
+// encrypt each record with AES-GCM, so values are not written in plaintext.
+type OpLogConfig struct {
+	Path          string `json:"path"`
+	EncryptionKey string `json:"encryption_key"`
+}
+
+// RedactionConfig masks the values of matching keys in /export and the op
+// log, for keys holding tokens or personal data. Reads are unaffected.
+type RedactionConfig struct {
+	KeyPrefixes []string `json:"key_prefixes"`
+	KeyPatterns []string `json:"key_patterns"` // Regular expressions
+}
+
+// StatsDConfig enables pushing metrics to a StatsD or DogStatsD endpoint
+// every interval (default 10 seconds); an empty address disables it
+type StatsDConfig struct {
+	Addr            string   `json:"addr"`
+	IntervalSeconds int      `json:"interval_seconds"`
+	Prefix          string   `json:"prefix"` // Metric name prefix, default "lrucache"
+	Tags            []string `json:"tags"`   // DogStatsD tags, e.g. ["env:prod"]
+}
+
+// HealthConfig sets the thresholds of the health score and when to shed
+// low-priority routes; a zero ShedBelow never sheds
+type HealthConfig struct {
+	ShedBelow     float64 `json:"shed_below"`       // Score between 0 and 1
+	MaxLockWaitMs float64 `json:"max_lock_wait_ms"` // p99 lock wait scoring 0 (default 50)
+	MaxGCPauseMs  float64 `json:"max_gc_pause_ms"`  // GC pause scoring 0 (default 100)
+	MinHitRatio   float64 `json:"min_hit_ratio"`    // Hit ratio scoring 1; 0 ignores the hit ratio
+	// Route templates shed first (default /scan, /export, /stats/history, /shadow)
+	LowPriorityRoutes []string `json:"low_priority_routes"`
+}
+
+// QoSConfig limits how many requests run at once; the rest wait in bounded
+// per-priority queues (default 64 each) for up to the queue timeout (default
+// 1000 ms). A zero MaxConcurrent disables queueing. HighPriority lists, as
+// ACL entries, the principals whose "X-Cache-Priority: high" is honored.
+type QoSConfig struct {
+	MaxConcurrent  int      `json:"max_concurrent"`
+	QueueLength    int      `json:"queue_length"`
+	QueueTimeoutMs int      `json:"queue_timeout_ms"`
+	HighPriority   []string `json:"high_priority"`
+}
+
+// LimitsConfig caps concurrent requests and open connections across all
+// listeners; anything beyond a cap gets an immediate 503. Zero is unlimited.
+type LimitsConfig struct {
+	MaxInFlight    int `json:"max_in_flight"`
+	MaxConnections int `json:"max_connections"`
+}
+
+// SlowLogConfig enables logging cache operations whose lock wait plus
+// execution reaches the threshold, keeping the most recent MaxLen (default
+// 128) for GET /slowlog; a zero threshold disables it
+type SlowLogConfig struct {
+	ThresholdUs int `json:"threshold_us"`
+	MaxLen      int `json:"max_len"`
+}
+
+// KeysConfig sets how keys received from clients are canonicalized, so
+// differently formatted spellings of a key reach the same entry. MaxLength
+// is in bytes after normalization; 0 is unlimited.
+type KeysConfig struct {
+	URLDecode bool `json:"url_decode"`
+	Trim      bool `json:"trim"`
+	Lowercase bool `json:"lowercase"`
+	MaxLength int  `json:"max_length"`
+}
+
+// AdminConfig holds settings for the admin endpoints
+type AdminConfig struct {
+	ReceiptSecret string `json:"receipt_secret"`
+}
+
+// IPRulesConfig lists the CIDR blocks allowed and denied for a route group
+type IPRulesConfig struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// AuthConfig enables authentication by HMAC request signing, with secrets
+// keyed by key ID, or by bearer JWTs when a JWKS URL is configured
+type AuthConfig struct {
+	Enabled        bool              `json:"enabled"`
+	HMACKeys       map[string]string `json:"hmac_keys"`
+	MaxSkewSeconds int               `json:"max_skew_seconds"`
+	// Peak signed requests per second the replay store is sized for; 0 uses 1000
+	MaxSignedRate int       `json:"max_signed_rate"`
+	JWT           JWTConfig `json:"jwt"`
+}
+
+// JWTConfig sets how bearer JWTs are verified and which claims grant access
+type JWTConfig struct {
+	JWKSURL  string `json:"jwks_url"`
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// Claim listing the route groups (read, write, admin) the token may use
+	GroupsClaim string `json:"groups_claim"`
+	// Claim listing the key prefixes the token may touch; absent allows all keys
+	PrefixesClaim string `json:"prefixes_claim"`
+	// Claim listing the roles matched against key ACLs
+	RolesClaim string `json:"roles_claim"`
+}
+
+// IdempotencyConfig sets how long responses to writes carrying an
+// Idempotency-Key are remembered; 0 uses the default of 24 hours
+type IdempotencyConfig struct {
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// ListenersConfig holds the per-protocol listener settings. HTTP serves the
+// whole API; Read and Write split it so reads can be exposed more broadly
+// than writes and admin endpoints.
+type ListenersConfig struct {
+	HTTP  ListenerConfig `json:"http"`
+	Read  ListenerConfig `json:"read"`
+	Write ListenerConfig `json:"write"`
+}
+
+// ListenerConfig controls whether a protocol listener runs and where it binds
+type ListenerConfig struct {
+	Enabled bool   `json:"enabled"`
+	Addr    string `json:"addr"`
+}
+
+// defaultConfig returns the configuration used when no config file is given
+func defaultConfig() Config {
+	return Config{
+		Capacity: 1024,
+		Listeners: ListenersConfig{
+			HTTP: ListenerConfig{Enabled: true, Addr: ":8080"},
+		},
+	}
+}
+
+// loadConfig reads a JSON config file on top of the defaults
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+// validate rejects settings the cache cannot run with
+func (cfg Config) validate() error {
+	if cfg.Capacity < 1 {
+		return fmt.Errorf("capacity must be at least 1, got %d", cfg.Capacity)
+	}
+	return nil
+}
+
+// preparedConfig holds the parts of a config that are compiled or checked
+// before anything is applied
+type preparedConfig struct {
+	ipRules           map[string]ipRules
+	redactionPrefixes []string
+	redactionPatterns []*regexp.Regexp
+	acl               []ACLRule
+	schemas           map[string]*Schema
+}
+
+// prepareConfig validates cfg and compiles what applyConfig installs, with
+// key prefixes canonicalized under cfg's own key rules
+func prepareConfig(cfg Config) (preparedConfig, error) {
+	var p preparedConfig
+	if err := cfg.validate(); err != nil {
+		return p, err
+	}
+	var err error
+	if p.ipRules, err = compileIPRules(cfg.IPRules); err != nil {
+		return p, err
+	}
+	if p.redactionPrefixes, p.redactionPatterns, err = compileRedaction(cfg.Redaction, cfg.Keys); err != nil {
+		return p, err
+	}
+	for _, rule := range cfg.ACL {
+		prefix, err := cfg.Keys.prefix(rule.Prefix)
+		if err != nil {
+			return p, fmt.Errorf("ACL prefix %q: %v", rule.Prefix, err)
+		}
+		rule.Prefix = prefix
+		p.acl = append(p.acl, rule)
+	}
+	p.schemas = make(map[string]*Schema, len(cfg.Schemas))
+	for prefix, schema := range cfg.Schemas {
+		canonical, err := cfg.Keys.prefix(prefix)
+		if err != nil {
+			return p, fmt.Errorf("schema prefix %q: %v", prefix, err)
+		}
+		if schema != nil {
+			p.schemas[canonical] = schema
+		}
+	}
+	for _, def := range cfg.Queries {
+		if err := def.validate(); err != nil {
+			return p, fmt.Errorf("query %q: %v", def.Name, err)
+		}
+	}
+	return p, nil
+}
+
+// applyConfig applies the runtime-adjustable settings of cfg. Everything is
+// checked before anything changes, so an invalid config leaves the running
+// one intact. ACL rules, schemas and queries replace the whole set, dropping
+// any added through the admin endpoints. The settings in restartSettings
+// only take effect on restart.
+func applyConfig(cfg Config) error {
+	p, err := prepareConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	setKeyRules(cfg.Keys)
+	setIPRules(p.ipRules)
+	redactions.set(p.redactionPrefixes, p.redactionPatterns)
+	acls.replace(p.acl)
+	schemas.replace(p.schemas)
+	queries.replace(cfg.Queries)
+	if memController != nil {
+		memController.setCeiling(cfg.Capacity)
+	} else {
+		cache.Resize(cfg.Capacity)
+	}
+	cache.SetMaxValueSize(cfg.MaxValueSize)
+	cache.SetDefaultExpiration(time.Duration(cfg.DefaultTTLSeconds) * time.Second)
+	for _, c := range corsHandlers {
+		c.update(cfg.CORS)
+	}
+	setReceiptKey(cfg.Admin.ReceiptSecret)
+	setRouteTimeouts(cfg.Timeouts)
+	setAuthConfig(cfg.Auth)
+	setIdempotencyConfig(cfg.Idempotency)
+	health.configure(cfg.Health)
+	qos.configure(cfg.QoS)
+	setLimits(cfg.Limits)
+	cache.slowlog.configure(cfg.SlowLog)
+	setAppliedConfig(cfg)
+	return nil
+}
+
+// restartSettings returns the names of the settings that differ between the
+// running config and cfg but are only read at startup
+func restartSettings(running, cfg Config) []string {
+	settings := []struct {
+		name          string
+		running, next interface{}
+	}{
+		{"shadow", running.Shadow, cfg.Shadow},
+		{"stats", running.Stats, cfg.Stats},
+		{"contention", running.Contention, cfg.Contention},
+		{"chaos", running.Chaos, cfg.Chaos},
+		// Values already stored were encoded with the running transforms
+		{"transforms", running.Transforms, cfg.Transforms},
+		{"encryption_keys", running.EncryptionKeys, cfg.EncryptionKeys},
+		{"memory", running.Memory, cfg.Memory},
+		{"eviction", running.Eviction, cfg.Eviction}, // Switch at runtime via /admin/policy
+		{"oplog", running.OpLog, cfg.OpLog},
+		{"statsd", running.StatsD, cfg.StatsD},
+		{"listeners", running.Listeners, cfg.Listeners},
+	}
+	var changed []string
+	for _, s := range settings {
+		if !reflect.DeepEqual(s.running, s.next) {
+			changed = append(changed, s.name)
+		}
+	}
+	return changed
+}
+
+// reloadConfig re-reads the config file and applies it
+func reloadConfig() error {
+	sdNotify("RELOADING=1")
+	defer sdNotify("READY=1")
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	appliedMu.Lock()
+	running := appliedConfig
+	appliedMu.Unlock()
+	if err := applyConfig(cfg); err != nil {
+		return err
+	}
+	logrus.Infof("config reloaded (capacity=%d)", cfg.Capacity)
+	if changed := restartSettings(running, cfg); len(changed) > 0 {
+		logrus.Warnf("config reload: %s changed and only take effect on restart", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// watchReloadSignal reloads the config every time the process receives SIGHUP
+func watchReloadSignal(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			if err := reloadConfig(); err != nil {
+				logrus.Errorf("reloading config: %v", err)
+			}
+		}
+	}
+}
+
+// handleReload handles the HTTP POST request to reload the config file
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := reloadConfig(); err != nil {
+		http.Error(w, "Reload failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+var corsHandlers []*reloadableCORS // The CORS middlewares in front of each router
+
+// reloadableCORS is a CORS middleware whose options can be swapped at runtime
+type reloadableCORS struct {
+	next http.Handler
+
+	mu      sync.RWMutex
+	handler http.Handler
+}
+
+// newReloadableCORS wraps next with the default CORS policy
+func newReloadableCORS(next http.Handler) *reloadableCORS {
+	return &reloadableCORS{next: next, handler: cors.Default().Handler(next)}
+}
+
+// update rebuilds the CORS middleware from cfg
+func (c *reloadableCORS) update(cfg CORSConfig) {
+	h := cors.Default().Handler(c.next)
+	if len(cfg.AllowedOrigins) > 0 {
+		h = cors.New(cors.Options{AllowedOrigins: cfg.AllowedOrigins}).Handler(c.next)
+	}
+
+	c.mu.Lock()
+	c.handler = h
+	c.mu.Unlock()
+}
+
+func (c *reloadableCORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.RLock()
+	h := c.handler
+	c.mu.RUnlock()
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// contentionDetector records how long callers wait for the cache lock and
+// warns when a wait exceeds the threshold
+type contentionDetector struct {
+	threshold time.Duration
+	waits     histogram
+	slow      uint64 // Waits over the threshold
+
+	mu       sync.Mutex
+	lastWarn time.Time
+}
+
+// ContentionStats reports lock wait percentiles and slow acquisitions
+type ContentionStats struct {
+	LockWait    LatencySummary `json:"lock_wait"`
+	SlowWaits   uint64         `json:"slow_waits"`
+	ThresholdMs int64          `json:"threshold_ms"`
+}
+
+// newContentionDetector creates a detector warning on waits over threshold
+func newContentionDetector(threshold time.Duration) *contentionDetector {
+	return &contentionDetector{threshold: threshold}
+}
+
+// observe records a lock wait, logging at most one warning per second
+func (d *contentionDetector) observe(wait time.Duration) {
+	d.waits.observe(wait)
+	if wait < d.threshold {
+		return
+	}
+	atomic.AddUint64(&d.slow, 1)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.lastWarn) < time.Second {
+		return
+	}
+	d.lastWarn = time.Now()
+	logrus.Warnf("cache lock acquisition took %s (threshold %s)", wait, d.threshold)
+}
+
+// stats returns the recorded lock wait statistics
+func (d *contentionDetector) stats() ContentionStats {
+	return ContentionStats{
+		LockWait:    d.waits.summary(),
+		SlowWaits:   atomic.LoadUint64(&d.slow),
+		ThresholdMs: d.threshold.Milliseconds(),
+	}
+}
+
+// lock acquires the cache lock, timing the wait when contention detection or
+// the slow log is enabled
+func (c *LRUCache) lock() {
+	if c.contention == nil && !c.slowlog.enabled() {
+		c.mu.Lock()
+		c.lockWait = 0
+		return
+	}
+	start := time.Now()
+	c.mu.Lock()
+	c.lockWait = time.Since(start)
+	if c.contention != nil {
+		c.contention.observe(c.lockWait)
+	}
+}
+
+// unlock releases the cache lock and then runs the removal callbacks queued
+// while it was held
+func (c *LRUCache) unlock() {
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	for _, fn := range pending {
+		fn()
+	}
+}
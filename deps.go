@@ -0,0 +1,53 @@
+package main
+
+// Entries may declare dependencies on other keys. Any write to or deletion
+// of a dependency invalidates its dependents, transitively; expiry or
+// eviction of a dependency does not, as its value did not change.
+
+// linkDependencies records that item depends on deps; the caller must hold c.mu
+func (c *LRUCache) linkDependencies(item *CacheItem, deps []string) {
+	item.dependsOn = nil
+	for _, dep := range deps {
+		if dep == item.Key {
+			continue
+		}
+		if c.dependents == nil {
+			c.dependents = make(map[string]map[string]struct{})
+		}
+		if c.dependents[dep] == nil {
+			c.dependents[dep] = make(map[string]struct{})
+		}
+		c.dependents[dep][item.Key] = struct{}{}
+		item.dependsOn = append(item.dependsOn, dep)
+	}
+}
+
+// unlinkDependencies forgets the dependencies of item; the caller must hold c.mu
+func (c *LRUCache) unlinkDependencies(item *CacheItem) {
+	for _, dep := range item.dependsOn {
+		delete(c.dependents[dep], item.Key)
+		if len(c.dependents[dep]) == 0 {
+			delete(c.dependents, dep)
+		}
+	}
+	item.dependsOn = nil
+}
+
+// invalidateDependents removes the entries depending on key, and in turn
+// their dependents; the caller must hold c.mu
+func (c *LRUCache) invalidateDependents(key string) {
+	dependents := c.dependents[key]
+	if len(dependents) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(dependents))
+	for k := range dependents {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		// Entries removed earlier in a cycle are already gone
+		if ele, ok := c.items[k]; ok {
+			c.removeElement(ele, RemovalInvalidated)
+		}
+	}
+}
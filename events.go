@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped
+const subscriberBuffer = 256
+
+// eventLogSize is how many recent events are kept for subscribers resuming
+// after a disconnect
+const eventLogSize = 4096
+
+// Event types published when cache entries change
+const (
+	EventSet    = "set"
+	EventEvict  = "evict"
+	EventExpire = "expire"
+	EventDelete = "delete"
+)
+
+// Event describes a change to a cache entry
+type Event struct {
+	Seq    uint64 `json:"seq"` // Increases by one with every event
+	Type   string `json:"type"`
+	Key    string `json:"key"`
+	Reason string `json:"reason,omitempty"` // Why the previous value was removed: expired, evicted, replaced or deleted
+}
+
+// eventBroker fans cache events out to subscribers, numbering them and
+// keeping the most recent in a ring so subscribers can resume
+type eventBroker struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+	seq  uint64  // Sequence number of the last event published
+	log  []Event // Ring of the last eventLogSize events; event n is at (n-1) % eventLogSize
+}
+
+// newEventBroker creates an empty eventBroker
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: make(map[chan Event]struct{})}
+}
+
+// subscribe returns a channel receiving all future events. The channel is
+// closed if the subscriber falls too far behind, so it must resync.
+func (b *eventBroker) subscribe() chan Event {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// subscribeSince is like subscribe, but also returns the logged events
+// after sequence number since. ok is false if some of them have already
+// left the log, in which case the subscriber must resync.
+func (b *eventBroker) subscribeSince(since uint64) (ch chan Event, missed []Event, ok bool) {
+	ch = make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[ch] = struct{}{}
+
+	oldest := uint64(1)
+	if b.seq > uint64(len(b.log)) {
+		oldest = b.seq - uint64(len(b.log)) + 1
+	}
+	// A sequence number from the future was issued before a restart
+	if since+1 < oldest || since > b.seq {
+		return ch, nil, false
+	}
+	for seq := since + 1; seq <= b.seq; seq++ {
+		missed = append(missed, b.log[(seq-1)%eventLogSize])
+	}
+	return ch, missed, true
+}
+
+// unsubscribe stops delivery to ch
+func (b *eventBroker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// publish delivers ev to every subscriber without blocking
+func (b *eventBroker) publish(ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	ev.Seq = b.seq
+	if len(b.log) < eventLogSize {
+		b.log = append(b.log, ev)
+	} else {
+		b.log[(ev.Seq-1)%eventLogSize] = ev
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// handleEvents handles the HTTP GET request streaming cache events as
+// server-sent events. Each carries its sequence number as the SSE id, so a
+// reconnecting client resumes after it by sending Last-Event-ID (or the
+// since parameter) and first receives the events it missed. If those have
+// left the log, a resync event tells the client to refetch instead.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	since := r.Header.Get("Last-Event-ID")
+	if v := r.URL.Query().Get("since"); v != "" {
+		since = v
+	}
+	var ch chan Event
+	var missed []Event
+	complete := true
+	if since == "" {
+		ch = cache.events.subscribe()
+	} else {
+		seq, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+		ch, missed, complete = cache.events.subscribeSince(seq)
+	}
+	defer cache.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if !complete {
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+	}
+	for _, ev := range missed {
+		writeEvent(w, r, ev)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if writeEvent(w, r, ev) {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// writeEvent writes ev as a server-sent event if the request may read its
+// key, reporting whether it did
+func writeEvent(w http.ResponseWriter, r *http.Request, ev Event) bool {
+	if !keyAllowed(r, ev.Key, AccessRead) {
+		return false
+	}
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "id: %d\ndata: %s\n\n", ev.Seq, data)
+	return true
+}
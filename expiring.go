@@ -0,0 +1,125 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errStoreFull is returned when an expiringStore has no room left for an entry
+var errStoreFull = errors.New("store full")
+
+// expiringEntryOverhead approximates the bookkeeping bytes of one entry
+const expiringEntryOverhead = 64
+
+// expiringStore is a small map of expiring entries kept outside the cache,
+// for server bookkeeping that must neither take cache capacity nor show up in
+// the keyspace. Its size is bounded in bytes. When full it only reclaims
+// expired entries and otherwise refuses new ones, since dropping a live entry
+// would silently break the guarantee it backs.
+type expiringStore struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	entries  map[string]*list.Element
+	order    *list.List // Insertion order, which is close to expiry order
+}
+
+type expiringEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// newExpiringStore creates a store holding at most maxBytes of keys and values
+func newExpiringStore(maxBytes int) *expiringStore {
+	return &expiringStore{maxBytes: maxBytes, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// setMaxBytes changes the size bound. Lowering it below the bytes in use
+// refuses new entries until enough have expired.
+func (s *expiringStore) setMaxBytes(maxBytes int) {
+	s.mu.Lock()
+	s.maxBytes = maxBytes
+	s.mu.Unlock()
+}
+
+// add stores value under key for ttl unless the key is already present,
+// reporting whether it was stored
+func (s *expiringStore) add(key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if ele, ok := s.entries[key]; ok {
+		if now.Before(ele.Value.(*expiringEntry).expires) {
+			return false, nil
+		}
+		s.remove(ele)
+	}
+	return true, s.insert(key, value, now.Add(ttl), now)
+}
+
+// set stores value under key for ttl, replacing any previous value
+func (s *expiringStore) set(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ele, ok := s.entries[key]; ok {
+		s.remove(ele)
+	}
+	now := time.Now()
+	return s.insert(key, value, now.Add(ttl), now)
+}
+
+// get returns the unexpired value stored under key
+func (s *expiringStore) get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ele, ok := s.entries[key]
+	if !ok || !time.Now().Before(ele.Value.(*expiringEntry).expires) {
+		return nil, false
+	}
+	return ele.Value.(*expiringEntry).value, true
+}
+
+// delete removes key if present
+func (s *expiringStore) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ele, ok := s.entries[key]; ok {
+		s.remove(ele)
+	}
+}
+
+// insert adds a new entry, reclaiming expired ones first if it would not fit
+func (s *expiringStore) insert(key string, value []byte, expires, now time.Time) error {
+	cost := len(key) + len(value) + expiringEntryOverhead
+	if s.bytes+cost > s.maxBytes {
+		s.prune(now)
+	}
+	if s.bytes+cost > s.maxBytes {
+		return errStoreFull
+	}
+	s.entries[key] = s.order.PushBack(&expiringEntry{key: key, value: value, expires: expires})
+	s.bytes += cost
+	return nil
+}
+
+// prune removes expired entries from the oldest on, stopping at the first
+// live one. Entries stored with a longer ttl can hold back later ones until
+// they expire, which only happens briefly after the ttl is lowered.
+func (s *expiringStore) prune(now time.Time) {
+	for ele := s.order.Front(); ele != nil && !now.Before(ele.Value.(*expiringEntry).expires); ele = s.order.Front() {
+		s.remove(ele)
+	}
+}
+
+func (s *expiringStore) remove(ele *list.Element) {
+	e := s.order.Remove(ele).(*expiringEntry)
+	delete(s.entries, e.key)
+	s.bytes -= len(e.key) + len(e.value) + expiringEntryOverhead
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportRecord is one line of an export: a cache entry with its absolute expiry
+type ExportRecord struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`         // Zero if the entry never expires
+	Redacted  bool      `json:"redacted,omitempty"` // The value was withheld by the redaction rules
+}
+
+// handleExport handles the HTTP GET request to export the cache. The entries
+// are copied in one step under the cache lock, so the export is a consistent
+// point-in-time view however long streaming it takes while writes continue.
+//
+// The default format is NDJSON, starting with a SnapshotHeader line; values
+// are exported as stored, i.e. still transformed, except that keys matching
+// the redaction rules are exported without a value. format=csv instead writes
+// one row of access statistics per entry, without values, for analysis.
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "ndjson" && format != "csv" {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	if !canonicalizePrefixes(w, &prefix) {
+		return
+	}
+	items, token := cache.Snapshot()
+	selected := items[:0]
+	for _, item := range items {
+		if strings.HasPrefix(item.Key, prefix) && keyAllowed(r, item.Key, AccessRead) {
+			selected = append(selected, item)
+		}
+	}
+
+	w.Header().Set("X-Snapshot-Token", strconv.FormatUint(token, 10))
+	if format == "csv" {
+		writeCSVExport(w, r, selected)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	header := newSnapshotHeader()
+	header.Token = token
+	if err := enc.Encode(header); err != nil {
+		return
+	}
+	for _, item := range selected {
+		if r.Context().Err() != nil {
+			return
+		}
+		rec := ExportRecord{Key: item.Key, Value: item.Value, ExpiresAt: item.Exp.UTC()}
+		if redactions.matches(item.Key) {
+			rec.Value, rec.Redacted = "", true
+		}
+		if err := enc.Encode(rec); err != nil {
+			return
+		}
+	}
+}
+
+// writeCSVExport writes the key, value size, remaining TTL, hit count and
+// last access time of each item, stopping if the request is cancelled
+func writeCSVExport(w http.ResponseWriter, r *http.Request, items []CacheItem) {
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"key", "size", "ttl_remaining_seconds", "hit_count", "last_access"})
+	now := time.Now()
+	for _, item := range items {
+		if r.Context().Err() != nil {
+			break
+		}
+		ttl := "" // Never expires
+		if !item.Exp.IsZero() {
+			ttl = strconv.FormatFloat(item.Exp.Sub(now).Seconds(), 'f', 3, 64)
+		}
+		lastAccess := ""
+		if !item.accessed.IsZero() {
+			lastAccess = item.accessed.UTC().Format(time.RFC3339Nano)
+		}
+		cw.Write([]string{
+			item.Key,
+			strconv.Itoa(len(item.Value)),
+			ttl,
+			strconv.FormatUint(item.hits, 10),
+			lastAccess,
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,23 @@
+package main
+
+import "expvar"
+
+// The cache counters are published under "lrucache" for the standard
+// library's /debug/vars, alongside its memstats and cmdline
+func init() {
+	expvar.Publish("lrucache", expvar.Func(func() interface{} {
+		if cache == nil {
+			return nil
+		}
+		st := cache.Stats()
+		policy, _ := cache.PolicyStats()
+		return map[string]interface{}{
+			"stats":    st,
+			"removals": st.removals(),
+			"size":     cache.Len(),
+			"capacity": cache.Capacity(),
+			"latency":  cache.latency.summaries(),
+			"policy":   policy,
+		}
+	}))
+}
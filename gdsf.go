@@ -0,0 +1,122 @@
+package main
+
+import "container/heap"
+
+// gdsfPolicy implements GreedyDual-Size-Frequency: each item's priority is
+// L + frequency * cost / size and the lowest priority is evicted, where the
+// inflation value L rises to the priority of every evicted item so that
+// items which stop being used eventually age out. Small, expensive and
+// frequently used values are kept over large, cheap or rarely used ones.
+type gdsfPolicy struct {
+	inflation float64
+	entries   map[string]*gdsfEntry
+	queue     gdsfQueue
+}
+
+type gdsfEntry struct {
+	key      string
+	freq     float64
+	priority float64
+	index    int
+}
+
+// GDSFStats reports the state of the GDSF policy
+type GDSFStats struct {
+	Inflation float64 `json:"inflation"`
+}
+
+func newGDSFPolicy() *gdsfPolicy {
+	return &gdsfPolicy{entries: make(map[string]*gdsfEntry)}
+}
+
+func (p *gdsfPolicy) name() string { return "gdsf" }
+
+// priority computes an item's priority for the given access frequency
+func (p *gdsfPolicy) priority(item *CacheItem, freq float64) float64 {
+	cost := item.Cost
+	if cost <= 0 {
+		cost = 1
+	}
+	size := float64(len(item.Key) + len(item.Value))
+	if size < 1 {
+		size = 1
+	}
+	return p.inflation + freq*cost/size
+}
+
+func (p *gdsfPolicy) added(item *CacheItem) {
+	e := &gdsfEntry{key: item.Key, freq: 1}
+	e.priority = p.priority(item, e.freq)
+	p.entries[item.Key] = e
+	heap.Push(&p.queue, e)
+}
+
+func (p *gdsfPolicy) accessed(item *CacheItem) {
+	e, ok := p.entries[item.Key]
+	if !ok {
+		return
+	}
+	e.freq++
+	e.priority = p.priority(item, e.freq)
+	heap.Fix(&p.queue, e.index)
+}
+
+func (p *gdsfPolicy) removed(item *CacheItem, reason RemovalReason) {
+	e, ok := p.entries[item.Key]
+	if !ok {
+		return
+	}
+	if reason == RemovalEvicted {
+		p.inflation = e.priority
+	}
+	heap.Remove(&p.queue, e.index)
+	delete(p.entries, item.Key)
+}
+
+func (p *gdsfPolicy) victim(incoming string) string {
+	if len(p.queue) == 0 {
+		return ""
+	}
+	if p.queue[0].key != incoming || len(p.queue) == 1 {
+		return p.queue[0].key
+	}
+	// the runner-up is one of the root's children
+	next := p.queue[1]
+	if len(p.queue) > 2 && p.queue[2].priority < next.priority {
+		next = p.queue[2]
+	}
+	return next.key
+}
+
+func (p *gdsfPolicy) resize(capacity int) {}
+
+func (p *gdsfPolicy) stats() interface{} {
+	return GDSFStats{Inflation: p.inflation}
+}
+
+func (p *gdsfPolicy) needsOrder() bool { return true }
+
+// gdsfQueue is a min-heap of entries by priority
+type gdsfQueue []*gdsfEntry
+
+func (q gdsfQueue) Len() int           { return len(q) }
+func (q gdsfQueue) Less(i, j int) bool { return q[i].priority < q[j].priority }
+
+func (q gdsfQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *gdsfQueue) Push(x interface{}) {
+	e := x.(*gdsfEntry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *gdsfQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
@@ -3,8 +3,9 @@ module lrucache
 go 1.21.1
 
 require (
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/rs/cors v1.10.1 // indirect
-	github.com/sirupsen/logrus v1.9.3 // indirect
-	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+	github.com/gorilla/mux v1.8.1
+	github.com/rs/cors v1.10.1
+	github.com/sirupsen/logrus v1.9.3
 )
+
+require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
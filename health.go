@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// healthInterval is how often the health score is sampled
+const healthInterval = 5 * time.Second
+
+// healthSmoothing is the weight of the newest sample in the rolling score
+const healthSmoothing = 0.3
+
+// Defaults for the health thresholds
+const (
+	defaultMaxLockWaitMs = 50
+	defaultMaxGCPauseMs  = 100
+)
+
+// defaultLowPriorityRoutes are shed first when the server is degraded
+var defaultLowPriorityRoutes = []string{"/scan", "/export", "/stats/history", "/shadow"}
+
+var health = newHealthMonitor() // Started in main, samples the health score
+
+// HealthReport holds the rolling health score and the latest per-signal
+// scores, each between 0 (bad) and 1 (healthy)
+type HealthReport struct {
+	Score    float64 `json:"score"`
+	Shedding bool    `json:"shedding"`
+	LockWait float64 `json:"lock_wait"`
+	GCPause  float64 `json:"gc_pause"`
+	HitRatio float64 `json:"hit_ratio"`
+	Memory   float64 `json:"memory"`
+}
+
+// healthMonitor computes a rolling health score from lock waits, GC pauses,
+// the hit ratio and memory headroom
+type healthMonitor struct {
+	mu          sync.Mutex
+	cfg         HealthConfig
+	lowPriority map[string]bool
+	report      HealthReport
+	lastStats   Stats
+	lastWaits   *histogram
+}
+
+// newHealthMonitor creates a monitor reporting full health until sampled
+func newHealthMonitor() *healthMonitor {
+	h := &healthMonitor{report: HealthReport{Score: 1, LockWait: 1, GCPause: 1, HitRatio: 1, Memory: 1}}
+	h.configure(HealthConfig{})
+	return h
+}
+
+// configure replaces the thresholds, filling in defaults
+func (h *healthMonitor) configure(cfg HealthConfig) {
+	if cfg.MaxLockWaitMs <= 0 {
+		cfg.MaxLockWaitMs = defaultMaxLockWaitMs
+	}
+	if cfg.MaxGCPauseMs <= 0 {
+		cfg.MaxGCPauseMs = defaultMaxGCPauseMs
+	}
+	if cfg.LowPriorityRoutes == nil {
+		cfg.LowPriorityRoutes = defaultLowPriorityRoutes
+	}
+	lowPriority := make(map[string]bool, len(cfg.LowPriorityRoutes))
+	for _, route := range cfg.LowPriorityRoutes {
+		lowPriority[route] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cfg = cfg
+	h.lowPriority = lowPriority
+	h.report.Shedding = cfg.ShedBelow > 0 && h.report.Score < cfg.ShedBelow
+}
+
+// run samples the health of c every healthInterval until ctx is done
+func (h *healthMonitor) run(ctx context.Context, c *LRUCache) {
+	h.mu.Lock()
+	h.lastStats = c.Stats()
+	if c.contention != nil {
+		h.lastWaits = c.contention.waits.clone()
+	}
+	h.mu.Unlock()
+
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.sample(c)
+		}
+	}
+}
+
+// sample scores each signal over the last interval and folds the worst
+// one into the rolling score
+func (h *healthMonitor) sample(c *LRUCache) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := &h.report
+
+	r.LockWait = 1
+	if c.contention != nil {
+		waits := c.contention.waits.clone()
+		if d := waits.delta(h.lastWaits); d.total > 0 {
+			r.LockWait = 1 - math.Min(d.summary().P99/1e3/h.cfg.MaxLockWaitMs, 1)
+		}
+		h.lastWaits = waits
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	r.GCPause = 1
+	if ms.NumGC > 0 {
+		pause := float64(ms.PauseNs[(ms.NumGC+255)%256]) / 1e6
+		r.GCPause = 1 - math.Min(pause/h.cfg.MaxGCPauseMs, 1)
+	}
+
+	st := c.Stats()
+	r.HitRatio = 1
+	hits, misses := st.Hits-h.lastStats.Hits, st.Misses-h.lastStats.Misses
+	if h.cfg.MinHitRatio > 0 && hits+misses > 0 {
+		r.HitRatio = math.Min(float64(hits)/float64(hits+misses)/h.cfg.MinHitRatio, 1)
+	}
+	h.lastStats = st
+
+	// Headroom only counts against health over the last 20% of the memory target
+	r.Memory = 1
+	if memController != nil {
+		r.Memory = math.Max(0, math.Min((1-memController.Pressure())*5, 1))
+	}
+
+	worst := math.Min(math.Min(r.LockWait, r.GCPause), math.Min(r.HitRatio, r.Memory))
+	r.Score = healthSmoothing*worst + (1-healthSmoothing)*r.Score
+	r.Shedding = h.cfg.ShedBelow > 0 && r.Score < h.cfg.ShedBelow
+}
+
+// Report returns the latest health scores
+func (h *healthMonitor) Report() HealthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.report
+}
+
+// shouldShed reports whether a request for route with the given priority is
+// shed in the current state. High-priority requests are never shed, and
+// low-priority ones are shed on any route.
+func (h *healthMonitor) shouldShed(route string, priority int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.report.Shedding || priority == PriorityHigh {
+		return false
+	}
+	return priority == PriorityLow || h.lowPriority[route]
+}
+
+// shedLowPriority is a middleware answering 503 to low-priority routes and
+// requests while the health score is below the shedding threshold
+func shedLowPriority(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if health.shouldShed(routeTemplate(r), requestPriority(r)) {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Server degraded, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleHealth handles the HTTP GET request reporting the health score
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(health.Report())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// historyBucket is the width of one stats history bucket
+const historyBucket = time.Minute
+
+var history *statsHistory // Set in main, records per-minute stats
+
+// StatsPoint holds the counter increments over one step of the stats history
+type StatsPoint struct {
+	Start time.Time `json:"start"`
+	Stats
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// statsHistory keeps per-minute deltas of the cache counters for a window
+type statsHistory struct {
+	cache     *LRUCache
+	retention int // Number of buckets kept
+
+	mu      sync.Mutex
+	last    Stats
+	buckets []StatsPoint // Oldest first
+}
+
+// newStatsHistory creates a history keeping retention of per-minute buckets
+func newStatsHistory(c *LRUCache, retention time.Duration) *statsHistory {
+	n := int(retention / historyBucket)
+	if n < 1 {
+		n = 60
+	}
+	return &statsHistory{cache: c, retention: n, last: c.Stats()}
+}
+
+// run closes a bucket every minute until ctx is done
+func (h *statsHistory) run(ctx context.Context) {
+	ticker := time.NewTicker(historyBucket)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.record(now)
+		}
+	}
+}
+
+// record closes the bucket ending at now with the counter increments since the last one
+func (h *statsHistory) record(now time.Time) {
+	st := h.cache.Stats()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buckets = append(h.buckets, StatsPoint{Start: now.Add(-historyBucket).UTC(), Stats: st.sub(h.last)})
+	h.last = st
+	if len(h.buckets) > h.retention {
+		h.buckets = h.buckets[len(h.buckets)-h.retention:]
+	}
+}
+
+// rollup returns the buckets of the last window summed into points of step
+func (h *statsHistory) rollup(window, step time.Duration) []StatsPoint {
+	per := int(step / historyBucket)
+	if per < 1 {
+		per = 1
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := h.buckets
+	if n := int(window / historyBucket); n < len(buckets) {
+		buckets = buckets[len(buckets)-n:]
+	}
+
+	points := []StatsPoint{}
+	for i := 0; i < len(buckets); i += per {
+		p := StatsPoint{Start: buckets[i].Start}
+		for _, b := range buckets[i:min(i+per, len(buckets))] {
+			p.Stats = p.Stats.add(b.Stats)
+		}
+		if total := p.Hits + p.Misses; total > 0 {
+			p.HitRatio = float64(p.Hits) / float64(total)
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// sub returns the counter increments from prev to s
+func (s Stats) sub(prev Stats) Stats {
+	return Stats{
+		Hits:          s.Hits - prev.Hits,
+		Misses:        s.Misses - prev.Misses,
+		Sets:          s.Sets - prev.Sets,
+		Deletes:       s.Deletes - prev.Deletes,
+		Evictions:     s.Evictions - prev.Evictions,
+		Expirations:   s.Expirations - prev.Expirations,
+		Replacements:  s.Replacements - prev.Replacements,
+		TooLarge:      s.TooLarge - prev.TooLarge,
+		Invalidations: s.Invalidations - prev.Invalidations,
+	}
+}
+
+// add returns the sum of two sets of counters
+func (s Stats) add(o Stats) Stats {
+	return Stats{
+		Hits:          s.Hits + o.Hits,
+		Misses:        s.Misses + o.Misses,
+		Sets:          s.Sets + o.Sets,
+		Deletes:       s.Deletes + o.Deletes,
+		Evictions:     s.Evictions + o.Evictions,
+		Expirations:   s.Expirations + o.Expirations,
+		Replacements:  s.Replacements + o.Replacements,
+		TooLarge:      s.TooLarge + o.TooLarge,
+		Invalidations: s.Invalidations + o.Invalidations,
+	}
+}
+
+// handleStatsHistory handles the HTTP GET request for the stats history,
+// e.g. /stats/history?window=1h&step=5m. Steps are whole minutes.
+func handleStatsHistory(w http.ResponseWriter, r *http.Request) {
+	type HistoryResponse struct {
+		Window string       `json:"window"`
+		Step   string       `json:"step"`
+		Points []StatsPoint `json:"points"`
+	}
+
+	window, step := time.Hour, historyBucket
+	var err error
+	if v := r.URL.Query().Get("window"); v != "" {
+		if window, err = time.ParseDuration(v); err != nil || window <= 0 {
+			http.Error(w, "Invalid window", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("step"); v != "" {
+		if step, err = time.ParseDuration(v); err != nil || step < historyBucket || step%historyBucket != 0 {
+			http.Error(w, "Invalid step, must be a whole number of minutes", http.StatusBadRequest)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(HistoryResponse{
+		Window: window.String(),
+		Step:   step.String(),
+		Points: history.rollup(window, step),
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validCacheControl reports whether s is a list of Cache-Control directives
+// that can be sent as a header: comma-separated tokens with optional values
+func validCacheControl(s string) bool {
+	for _, directive := range strings.Split(s, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" && s != "" {
+			return false
+		}
+		for _, c := range directive {
+			if c < 0x20 || c == 0x7f || c > 0x7e {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writeCacheControl sets the Cache-Control and Expires headers of a hit on
+// an entry stored with Cache-Control directives, so HTTP caches in front of
+// the server drop it no later than the cache does. Caches subtract Age from
+// the freshness lifetime, so max-age and s-maxage are capped at the entry's
+// age plus its remaining TTL, and max-age is added if missing. Entries that
+// never expire get the directives unchanged.
+func writeCacheControl(w http.ResponseWriter, info ItemInfo) {
+	if info.TTL == NoExpiration {
+		w.Header().Set("Cache-Control", info.CacheControl)
+		return
+	}
+
+	ttl := max(info.TTL, 0)
+	lifetime := int64((info.Age + ttl) / time.Second)
+	if info.CreatedAt.IsZero() { // No Age header is sent
+		lifetime = int64(ttl / time.Second)
+	}
+
+	directives := strings.Split(info.CacheControl, ",")
+	hasMaxAge := false
+	for i, directive := range directives {
+		directives[i] = strings.TrimSpace(directive)
+		name, value, _ := strings.Cut(directives[i], "=")
+		name = strings.ToLower(name)
+		if name != "max-age" && name != "s-maxage" {
+			continue
+		}
+		hasMaxAge = hasMaxAge || name == "max-age"
+		if n, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64); err != nil || n > lifetime {
+			directives[i] = name + "=" + strconv.FormatInt(lifetime, 10)
+		}
+	}
+	if !hasMaxAge {
+		directives = append(directives, "max-age="+strconv.FormatInt(lifetime, 10))
+	}
+
+	w.Header().Set("Cache-Control", strings.Join(directives, ", "))
+	w.Header().Set("Expires", time.Now().Add(ttl).UTC().Format(http.TimeFormat))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Headers of the idempotent write protocol
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	headerReplayed       = "Idempotent-Replayed"
+)
+
+// idempotencyStoreBytes bounds the memory used for remembered responses
+const idempotencyStoreBytes = 64 << 20
+
+// maxIdempotentResponse is the largest response body that is remembered;
+// requests with larger responses can be retried but are not replayed
+const maxIdempotentResponse = 64 << 10
+
+// idempotencyRecords holds the remembered responses, outside the cache
+// keyspace so they take no capacity and cannot be read or deleted
+var idempotencyRecords = newExpiringStore(idempotencyStoreBytes)
+
+// defaultIdempotencyWindow is how long responses are remembered when unconfigured
+const defaultIdempotencyWindow = 24 * time.Hour
+
+var (
+	idempotencyMu     sync.RWMutex
+	idempotencyWindow = defaultIdempotencyWindow
+)
+
+// setIdempotencyConfig installs the idempotency settings
+func setIdempotencyConfig(cfg IdempotencyConfig) {
+	window := time.Duration(cfg.WindowSeconds) * time.Second
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+
+	idempotencyMu.Lock()
+	idempotencyWindow = window
+	idempotencyMu.Unlock()
+}
+
+// idempotentResponse is a remembered response, stored as JSON.
+// A record without a status belongs to a request still in progress.
+type idempotentResponse struct {
+	Request     string `json:"request"` // Hash of the method, path and body
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// idempotent is a middleware that remembers the response to a write carrying
+// an Idempotency-Key header and replays it on retries with the same key, so
+// a retried conditional write does not apply twice. Keys are scoped to the
+// authenticated principal. Server errors and responses over
+// maxIdempotentResponse are not remembered so the request can be retried.
+// When the store is full of live records the write is refused with 503.
+func idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idemKey := r.Header.Get(headerIdempotencyKey)
+		if idemKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := bufferBody(r)
+		if err == errBodyTooLarge {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		sum := sha256.New()
+		io.WriteString(sum, r.Method+"\n"+r.URL.Path+"\n")
+		sum.Write(body)
+		fingerprint := hex.EncodeToString(sum.Sum(nil))
+
+		owner := ""
+		if p, ok := principalFrom(r); ok {
+			owner = p.ID
+		}
+		recordKey := owner + ":" + idemKey
+
+		idempotencyMu.RLock()
+		window := idempotencyWindow
+		idempotencyMu.RUnlock()
+
+		pending, _ := json.Marshal(idempotentResponse{Request: fingerprint})
+		added, err := idempotencyRecords.add(recordKey, pending, window)
+		if err != nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many idempotent requests remembered, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		if !added {
+			replayResponse(w, recordKey, fingerprint)
+			return
+		}
+
+		rec := &recordingResponse{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		if rec.code >= 500 || rec.truncated {
+			idempotencyRecords.delete(recordKey)
+			return
+		}
+		record, _ := json.Marshal(idempotentResponse{
+			Request:     fingerprint,
+			Status:      rec.code,
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		})
+		if idempotencyRecords.set(recordKey, record, window) != nil {
+			idempotencyRecords.delete(recordKey)
+		}
+	})
+}
+
+// recordingResponse passes a response through to the client while keeping a
+// copy of its status and, up to maxIdempotentResponse, its body
+type recordingResponse struct {
+	http.ResponseWriter
+	code      int
+	body      bytes.Buffer
+	truncated bool // The body outgrew the copy
+}
+
+func (rec *recordingResponse) WriteHeader(code int) {
+	if rec.code == 0 {
+		rec.code = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *recordingResponse) Write(p []byte) (int, error) {
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	if rec.body.Len()+len(p) > maxIdempotentResponse {
+		rec.truncated = true
+	} else if !rec.truncated {
+		rec.body.Write(p)
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// replayResponse answers a retry with the remembered response for recordKey
+func replayResponse(w http.ResponseWriter, recordKey, fingerprint string) {
+	raw, ok := idempotencyRecords.get(recordKey)
+	var prev idempotentResponse
+	if !ok || json.Unmarshal(raw, &prev) != nil {
+		http.Error(w, "Idempotency key state lost, retry the request", http.StatusConflict)
+		return
+	}
+	if prev.Request != fingerprint {
+		http.Error(w, "Idempotency key reused with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	if prev.Status == 0 {
+		http.Error(w, "A request with this idempotency key is in progress", http.StatusConflict)
+		return
+	}
+
+	if prev.ContentType != "" {
+		w.Header().Set("Content-Type", prev.ContentType)
+	}
+	w.Header().Set(headerReplayed, "true")
+	w.WriteHeader(prev.Status)
+	w.Write(prev.Body)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// importBatchSize is how many records are applied per cache lock acquisition
+const importBatchSize = 1000
+
+// ImportSummary reports the outcome of an import
+type ImportSummary struct {
+	Records  int    `json:"records"`
+	Imported int    `json:"imported"`
+	Expired  int    `json:"expired"`
+	Rejected int    `json:"rejected"` // Invalid keys, or refused by ACLs or the maximum value size
+	Redacted int    `json:"redacted"` // Exported without a value, so skipped
+	Error    string `json:"error,omitempty"`
+}
+
+// handleImport handles the HTTP POST request loading NDJSON in the /export
+// format. Records are decoded as they stream in and applied in batches, so
+// the payload is never held in memory; while the heap is over the memory
+// reject ratio the import pauses, which pushes back on the sender. Values
+// are stored as given, i.e. already transformed. The summary is sent as the
+// body and repeated in X-Import-* trailers.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Trailer", "X-Import-Records, X-Import-Imported, X-Import-Error")
+
+	var summary ImportSummary
+	err := importRecords(r, &summary)
+	if err != nil {
+		summary.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	json.NewEncoder(w).Encode(summary)
+	w.Header().Set("X-Import-Records", strconv.Itoa(summary.Records))
+	w.Header().Set("X-Import-Imported", strconv.Itoa(summary.Imported))
+	w.Header().Set("X-Import-Error", summary.Error)
+}
+
+// importRecords applies the records of the request body, counting them in summary
+func importRecords(r *http.Request, summary *ImportSummary) error {
+	snap, err := newSnapshotReader(r.Body)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]CacheItem, 0, importBatchSize)
+	flush := func() error {
+		if err := waitForMemory(r); err != nil {
+			return err
+		}
+		stored, expired := cache.SetItems(batch)
+		summary.Imported += stored
+		summary.Expired += expired
+		summary.Rejected += len(batch) - stored - expired
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		var rec ExportRecord
+		err := snap.next(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		summary.Records++
+		if rec.Redacted {
+			summary.Redacted++
+			continue
+		}
+		if rec.Key, err = canonicalKey(rec.Key); err != nil || rec.Key == "" || !keyAllowed(r, rec.Key, AccessWrite) {
+			summary.Rejected++
+			continue
+		}
+		batch = append(batch, CacheItem{Key: rec.Key, Value: rec.Value, Exp: rec.ExpiresAt})
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// waitForMemory blocks while the heap is over the memory reject ratio, until
+// the request is cancelled
+func waitForMemory(r *http.Request) error {
+	for overRejectRatio() {
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-time.After(memController.interval):
+		}
+	}
+	return nil
+}
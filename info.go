@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var startedAt = time.Now() // Process start, for uptime
+
+var (
+	appliedMu     sync.Mutex
+	appliedConfig Config // The config most recently applied, summarized by /info
+)
+
+// setAppliedConfig records cfg as the running configuration
+func setAppliedConfig(cfg Config) {
+	appliedMu.Lock()
+	appliedConfig = cfg
+	appliedMu.Unlock()
+}
+
+// handleInfo handles the HTTP GET request returning a one-shot diagnostic
+// dump of the server, in the spirit of Redis's INFO
+func handleInfo(w http.ResponseWriter, r *http.Request) {
+	type ServerInfo struct {
+		BuildInfo
+		PID           int     `json:"pid"`
+		UptimeSeconds float64 `json:"uptime_seconds"`
+		ConfigPath    string  `json:"config_path,omitempty"`
+		ReadOnly      bool    `json:"read_only"`
+	}
+	type ConfigInfo struct {
+		Capacity     int             `json:"capacity"`
+		MaxValueSize int             `json:"max_value_size"`
+		Policy       string          `json:"policy"`
+		Listeners    ListenersConfig `json:"listeners"`
+		MemoryTarget uint64          `json:"memory_target_bytes,omitempty"`
+		Auth         bool            `json:"auth"`
+		ACLRules     int             `json:"acl_rules"`
+		Contention   bool            `json:"contention"`
+	}
+	type MemoryInfo struct {
+		HeapAlloc    uint64  `json:"heap_alloc_bytes"`
+		HeapSys      uint64  `json:"heap_sys_bytes"`
+		NumGC        uint32  `json:"num_gc"`
+		LastGCPause  uint64  `json:"last_gc_pause_ns"`
+		Goroutines   int     `json:"goroutines"`
+		Pressure     float64 `json:"pressure,omitempty"` // Heap relative to the memory target
+		CacheEntries int     `json:"cache_entries"`
+	}
+	type PersistenceInfo struct {
+		OpLog string `json:"oplog,omitempty"` // File operations are recorded to
+	}
+	type ClusterInfo struct {
+		Mode string `json:"mode"`
+	}
+	type InfoResponse struct {
+		Server      ServerInfo      `json:"server"`
+		Config      ConfigInfo      `json:"config"`
+		Memory      MemoryInfo      `json:"memory"`
+		Persistence PersistenceInfo `json:"persistence"`
+		Cluster     ClusterInfo     `json:"cluster"`
+		Stats       Stats           `json:"stats"`
+		Health      HealthReport    `json:"health"`
+		QoS         QoSStats        `json:"qos"`
+		Limits      LimitStats      `json:"limits"`
+	}
+
+	appliedMu.Lock()
+	cfg := appliedConfig
+	appliedMu.Unlock()
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	resp := InfoResponse{
+		Server: ServerInfo{
+			BuildInfo:     buildInfo(),
+			PID:           os.Getpid(),
+			UptimeSeconds: time.Since(startedAt).Seconds(),
+			ConfigPath:    configPath,
+			ReadOnly:      readOnlyState().Enabled,
+		},
+		Config: ConfigInfo{
+			Capacity:     cache.Capacity(),
+			MaxValueSize: cfg.MaxValueSize,
+			Listeners:    cfg.Listeners,
+			MemoryTarget: cfg.Memory.TargetBytes,
+			Auth:         cfg.Auth.Enabled,
+			ACLRules:     len(acls.list()),
+			Contention:   cache.contention != nil,
+		},
+		Memory: MemoryInfo{
+			HeapAlloc:    ms.HeapAlloc,
+			HeapSys:      ms.HeapSys,
+			NumGC:        ms.NumGC,
+			LastGCPause:  ms.PauseNs[(ms.NumGC+255)%256],
+			Goroutines:   runtime.NumGoroutine(),
+			CacheEntries: cache.Len(),
+		},
+		Persistence: PersistenceInfo{OpLog: cache.OpLogPath()},
+		Cluster:     ClusterInfo{Mode: "standalone"},
+		Stats:       cache.Stats(),
+		Health:      health.Report(),
+		QoS:         qos.stats(),
+		Limits:      limits.stats(),
+	}
+	resp.Config.Policy, _ = cache.PolicyStats()
+	if memController != nil {
+		resp.Memory.Pressure = memController.Pressure()
+	}
+	json.NewEncoder(w).Encode(resp)
+}
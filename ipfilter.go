@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// ipRules is a compiled allow/deny list for one route group
+type ipRules struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+var (
+	ipRulesMu sync.RWMutex
+	groupIPs  map[string]ipRules // Keyed by route group
+)
+
+// compileIPRules parses the per-group CIDR lists
+func compileIPRules(cfg map[string]IPRulesConfig) (map[string]ipRules, error) {
+	compiled := make(map[string]ipRules, len(cfg))
+	for group, rc := range cfg {
+		var rules ipRules
+		var err error
+		if rules.allow, err = parseCIDRs(rc.Allow); err != nil {
+			return nil, fmt.Errorf("%s allow list: %v", group, err)
+		}
+		if rules.deny, err = parseCIDRs(rc.Deny); err != nil {
+			return nil, fmt.Errorf("%s deny list: %v", group, err)
+		}
+		compiled[group] = rules
+	}
+	return compiled, nil
+}
+
+// setIPRules installs compiled per-group CIDR lists
+func setIPRules(compiled map[string]ipRules) {
+	ipRulesMu.Lock()
+	groupIPs = compiled
+	ipRulesMu.Unlock()
+}
+
+// parseCIDRs parses CIDR blocks, accepting bare IPs as single-address blocks
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		if ip := net.ParseIP(c); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// allowed reports whether ip may access the group: deny entries always win,
+// and a non-empty allow list must contain the address
+func (rules ipRules) allowed(ip net.IP) bool {
+	for _, n := range rules.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(rules.allow) == 0 {
+		return true
+	}
+	for _, n := range rules.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilter returns a middleware enforcing the CIDR lists of a route group
+func ipFilter(group string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !ipAllowed(r, group) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ipAllowed reports whether the request's client address may use group
+func ipAllowed(r *http.Request, group string) bool {
+	ipRulesMu.RLock()
+	rules := groupIPs[group]
+	ipRulesMu.RUnlock()
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && rules.allowed(ip)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval limits how often unknown key IDs trigger a JWKS refetch
+const jwksRefreshInterval = time.Minute
+
+// jwksCache holds the verification keys fetched from a JWKS URL
+type jwksCache struct {
+	url string
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	fetching  *jwksFetch // The fetch in flight, if any
+}
+
+// jwksFetch is a JWKS download shared by every request waiting on it
+type jwksFetch struct {
+	done chan struct{} // Closed once the fetch finished
+	err  error
+}
+
+var (
+	jwksMu sync.Mutex
+	jwks   *jwksCache
+)
+
+// jwksFor returns the key cache for url, replacing it if the URL changed
+func jwksFor(url string) *jwksCache {
+	jwksMu.Lock()
+	defer jwksMu.Unlock()
+	if jwks == nil || jwks.url != url {
+		jwks = &jwksCache{url: url, keys: make(map[string]crypto.PublicKey)}
+	}
+	return jwks
+}
+
+// key returns the public key with the given ID, refetching the set when the
+// ID is unknown and the last fetch is old enough. The fetch runs without
+// the lock, so requests for known keys never wait on it; requests for
+// unknown ones wait for the fetch already in flight rather than start
+// another.
+func (j *jwksCache) key(kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	if k, ok := j.keys[kid]; ok {
+		j.mu.Unlock()
+		return k, nil
+	}
+	call := j.fetching
+	if call == nil {
+		if time.Since(j.fetchedAt) < jwksRefreshInterval {
+			j.mu.Unlock()
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		call = &jwksFetch{done: make(chan struct{})}
+		j.fetching, j.fetchedAt = call, time.Now()
+		go j.fetch(call)
+	}
+	j.mu.Unlock()
+
+	<-call.done
+	if call.err != nil {
+		return nil, call.err
+	}
+	j.mu.Lock()
+	k, ok := j.keys[kid]
+	j.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return k, nil
+}
+
+// fetch downloads the key set for call and installs it if it parses
+func (j *jwksCache) fetch(call *jwksFetch) {
+	keys, err := fetchJWKS(j.url)
+	j.mu.Lock()
+	if err == nil {
+		j.keys = keys
+	}
+	j.fetching = nil
+	j.mu.Unlock()
+	call.err = err
+	close(call.done)
+}
+
+// fetchJWKS downloads and parses the key set at url
+func fetchJWKS(url string) (map[string]crypto.PublicKey, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+// verifyJWT checks the signature (RS256 or ES256) and the time, issuer and
+// audience claims of a compact JWT, returning its claims
+func verifyJWT(token string, cfg JWTConfig) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwksFor(cfg.JWKSURL).key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) != nil {
+			return nil, errors.New("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 {
+			return nil, errors.New("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return nil, errors.New("invalid signature")
+		}
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := float64(authClock.Now().Unix())
+	exp, ok := claims["exp"].(float64)
+	if !ok || now >= exp {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, errors.New("token not yet valid")
+	}
+	if cfg.Issuer != "" && claims["iss"] != cfg.Issuer {
+		return nil, errors.New("unexpected issuer")
+	}
+	if cfg.Audience != "" && !containsClaim(claims["aud"], cfg.Audience) {
+		return nil, errors.New("unexpected audience")
+	}
+	return claims, nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimStrings returns a claim that is a string or array of strings as a
+// slice; a missing claim returns nil
+func claimStrings(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		return strings.Fields(val)
+	case []interface{}:
+		out := make([]string, 0, len(val))
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// containsClaim reports whether a string or string-array claim contains want
+func containsClaim(v interface{}, want string) bool {
+	for _, s := range claimStrings(v) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
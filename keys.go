@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrKeyTooLong is returned for a key over the configured maximum length
+var ErrKeyTooLong = errors.New("key too long")
+
+var (
+	keyRulesMu sync.RWMutex
+	keyRules   KeysConfig // How incoming keys are canonicalized
+)
+
+// setKeyRules replaces the key canonicalization settings. Entries already
+// stored under non-canonical keys are left alone.
+func setKeyRules(cfg KeysConfig) {
+	keyRulesMu.Lock()
+	keyRules = cfg
+	keyRulesMu.Unlock()
+}
+
+// canonicalKey normalizes a key received from a client: it is URL-decoded,
+// trimmed and lowercased as configured, then checked against the maximum length
+func canonicalKey(key string) (string, error) {
+	keyRulesMu.RLock()
+	rules := keyRules
+	keyRulesMu.RUnlock()
+
+	key, err := rules.normalize(key)
+	if err != nil {
+		return "", err
+	}
+	if rules.MaxLength > 0 && len(key) > rules.MaxLength {
+		return "", fmt.Errorf("%w: %d bytes, maximum is %d", ErrKeyTooLong, len(key), rules.MaxLength)
+	}
+	return key, nil
+}
+
+// canonicalPrefix normalizes a key prefix like canonicalKey, without the
+// length check; trimming is skipped on the right so "user: " stays a prefix
+// of "user: x"
+func canonicalPrefix(prefix string) (string, error) {
+	keyRulesMu.RLock()
+	rules := keyRules
+	keyRulesMu.RUnlock()
+	return rules.prefix(prefix)
+}
+
+// prefix normalizes a key prefix under these rules, as canonicalPrefix does
+// under the current ones
+func (k KeysConfig) prefix(prefix string) (string, error) {
+	trim := k.Trim
+	k.Trim = false
+	prefix, err := k.normalize(prefix)
+	if err != nil {
+		return "", err
+	}
+	if trim {
+		prefix = strings.TrimLeftFunc(prefix, unicode.IsSpace)
+	}
+	return prefix, nil
+}
+
+// normalize applies the URL-decode, trim and lowercase rules in that order
+func (k KeysConfig) normalize(key string) (string, error) {
+	if k.URLDecode {
+		decoded, err := url.PathUnescape(key)
+		if err != nil {
+			return "", fmt.Errorf("invalid key encoding: %w", err)
+		}
+		key = decoded
+	}
+	if k.Trim {
+		key = strings.TrimSpace(key)
+	}
+	if k.Lowercase {
+		key = strings.ToLower(key)
+	}
+	return key, nil
+}
+
+// canonicalizeKeys rewrites each key in place to its canonical form,
+// answering 400 and returning false if one is invalid
+func canonicalizeKeys(w http.ResponseWriter, keys ...*string) bool {
+	for _, key := range keys {
+		canonical, err := canonicalKey(*key)
+		if err != nil {
+			http.Error(w, "Invalid key: "+err.Error(), http.StatusBadRequest)
+			return false
+		}
+		*key = canonical
+	}
+	return true
+}
+
+// canonicalizePrefixes rewrites each key prefix in place to its canonical
+// form, answering 400 and returning false if one is invalid
+func canonicalizePrefixes(w http.ResponseWriter, prefixes ...*string) bool {
+	for _, prefix := range prefixes {
+		canonical, err := canonicalPrefix(*prefix)
+		if err != nil {
+			http.Error(w, "Invalid prefix: "+err.Error(), http.StatusBadRequest)
+			return false
+		}
+		*prefix = canonical
+	}
+	return true
+}
+
+// partsPrefix encodes the leading parts of a composite key as a prefix
+// matching only keys that start with all of them. Parts are joined with ':'
+// and have their colons and backslashes escaped with a backslash, as built
+// by client.Key.
+func partsPrefix(parts []string) string {
+	var b strings.Builder
+	for _, part := range parts {
+		for i := 0; i < len(part); i++ {
+			if c := part[i]; c == '\\' || c == ':' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(part[i])
+		}
+		b.WriteByte(':')
+	}
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// histogramSubBuckets is the number of linear sub-buckets per power of two,
+// bounding the relative error of a recorded value to about 1/histogramSubBuckets
+const histogramSubBuckets = 8
+
+// histogram is a log-linear latency histogram in the spirit of HDR histograms
+type histogram struct {
+	mu     sync.Mutex
+	counts [64 * histogramSubBuckets]uint64
+	total  uint64
+}
+
+// bucketFor returns the bucket index of a duration in nanoseconds
+func bucketFor(ns uint64) int {
+	if ns < histogramSubBuckets {
+		return int(ns)
+	}
+	exp := bits.Len64(ns) - 1 // ns is in [2^exp, 2^(exp+1))
+	shift := exp - 3          // log2(histogramSubBuckets)
+	sub := int(ns>>uint(shift)) - histogramSubBuckets
+	return (exp-2)*histogramSubBuckets + sub
+}
+
+// bucketUpper returns the largest duration in nanoseconds falling in bucket i
+func bucketUpper(i int) uint64 {
+	if i < histogramSubBuckets {
+		return uint64(i)
+	}
+	exp := i/histogramSubBuckets + 2
+	sub := uint64(i%histogramSubBuckets + histogramSubBuckets)
+	shift := uint(exp - 3)
+	return (sub+1)<<shift - 1
+}
+
+// observe records one duration
+func (h *histogram) observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.mu.Lock()
+	h.counts[bucketFor(uint64(d))]++
+	h.total++
+	h.mu.Unlock()
+}
+
+// clone returns a copy of the recorded counts
+func (h *histogram) clone() *histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return &histogram{counts: h.counts, total: h.total}
+}
+
+// delta returns a histogram of the durations recorded since prev, an earlier clone
+func (h *histogram) delta(prev *histogram) *histogram {
+	d := h.clone()
+	for i, n := range prev.counts {
+		d.counts[i] -= n
+	}
+	d.total -= prev.total
+	return d
+}
+
+// LatencySummary reports percentiles of a histogram in microseconds
+type LatencySummary struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50_us"`
+	P95   float64 `json:"p95_us"`
+	P99   float64 `json:"p99_us"`
+}
+
+// summary computes the p50/p95/p99 of the recorded durations
+func (h *histogram) summary() LatencySummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := LatencySummary{Count: h.total}
+	if h.total == 0 {
+		return s
+	}
+	s.P50 = h.quantile(0.50)
+	s.P95 = h.quantile(0.95)
+	s.P99 = h.quantile(0.99)
+	return s
+}
+
+// quantile returns the upper bound of the bucket holding quantile q, in
+// microseconds; the caller must hold h.mu
+func (h *histogram) quantile(q float64) float64 {
+	rank := uint64(q * float64(h.total))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return float64(bucketUpper(i)) / 1e3
+		}
+	}
+	return 0
+}
+
+// Operations whose lock hold time is recorded
+const (
+	OpGet    = "get"
+	OpMGet   = "mget"
+	OpSet    = "set"
+	OpDelete = "delete"
+)
+
+// latencyTracker holds one histogram per cache operation
+type latencyTracker struct {
+	ops map[string]*histogram
+}
+
+// newLatencyTracker creates histograms for every tracked operation
+func newLatencyTracker() *latencyTracker {
+	t := &latencyTracker{ops: make(map[string]*histogram)}
+	for _, op := range []string{OpGet, OpMGet, OpSet, OpDelete} {
+		t.ops[op] = &histogram{}
+	}
+	return t
+}
+
+// summaries returns the percentiles of every operation
+func (t *latencyTracker) summaries() map[string]LatencySummary {
+	s := make(map[string]LatencySummary, len(t.ops))
+	for op, h := range t.ops {
+		s[op] = h.summary()
+	}
+	return s
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// connRejection is written to connections accepted over the limit before closing them
+const connRejection = "HTTP/1.1 503 Service Unavailable\r\nConnection: close\r\nRetry-After: 1\r\nContent-Length: 0\r\n\r\n"
+
+var limits requestLimits // Process-wide caps shared by every listener
+
+// LimitStats reports the current load against the configured caps
+type LimitStats struct {
+	InFlight            int64  `json:"in_flight"`
+	MaxInFlight         int64  `json:"max_in_flight"`
+	Connections         int64  `json:"connections"`
+	MaxConnections      int64  `json:"max_connections"`
+	RejectedRequests    uint64 `json:"rejected_requests"`
+	RejectedConnections uint64 `json:"rejected_connections"`
+}
+
+// requestLimits counts in-flight requests and open connections; a zero cap
+// is unlimited
+type requestLimits struct {
+	maxInFlight, inFlight int64
+	maxConns, conns       int64
+	rejectedRequests      uint64
+	rejectedConns         uint64
+}
+
+// setLimits replaces the caps; connections already open over a lowered cap
+// are left alone
+func setLimits(cfg LimitsConfig) {
+	atomic.StoreInt64(&limits.maxInFlight, int64(cfg.MaxInFlight))
+	atomic.StoreInt64(&limits.maxConns, int64(cfg.MaxConnections))
+}
+
+// stats returns the current counts
+func (l *requestLimits) stats() LimitStats {
+	return LimitStats{
+		InFlight:            atomic.LoadInt64(&l.inFlight),
+		MaxInFlight:         atomic.LoadInt64(&l.maxInFlight),
+		Connections:         atomic.LoadInt64(&l.conns),
+		MaxConnections:      atomic.LoadInt64(&l.maxConns),
+		RejectedRequests:    atomic.LoadUint64(&l.rejectedRequests),
+		RejectedConnections: atomic.LoadUint64(&l.rejectedConns),
+	}
+}
+
+// limitInFlight is a middleware answering 503 immediately once the cap on
+// concurrent requests is reached, rather than letting them queue up.
+// Streaming routes are not counted, as they would hold a slot for their
+// lifetime.
+func limitInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamingRoutes[routeTemplate(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		n := atomic.AddInt64(&limits.inFlight, 1)
+		defer atomic.AddInt64(&limits.inFlight, -1)
+		if max := atomic.LoadInt64(&limits.maxInFlight); max > 0 && n > max {
+			atomic.AddUint64(&limits.rejectedRequests, 1)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests in flight", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitListener wraps a listener, answering connections over the cap with a
+// bare 503 and closing them
+type limitListener struct {
+	net.Listener
+}
+
+func (l limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		n := atomic.AddInt64(&limits.conns, 1)
+		if max := atomic.LoadInt64(&limits.maxConns); max <= 0 || n <= max {
+			return &countedConn{Conn: conn}, nil
+		}
+		atomic.AddInt64(&limits.conns, -1)
+		atomic.AddUint64(&limits.rejectedConns, 1)
+		conn.Write([]byte(connRejection))
+		conn.Close()
+	}
+}
+
+// countedConn releases its slot in the connection count when closed
+type countedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&limits.conns, -1) })
+	return c.Conn.Close()
+}
+
+// maxBufferedBody caps the request bodies middleware reads into memory when
+// no maximum value size is configured
+const maxBufferedBody = 8 << 20
+
+// bodyFraming is the room left above the maximum value size for the rest of
+// a buffered request body, such as the key and JSON syntax
+const bodyFraming = 64 << 10
+
+// errBodyTooLarge is returned by bufferBody for bodies over the cap
+var errBodyTooLarge = errors.New("request body too large")
+
+// bufferBody reads the request body into memory and puts it back so the
+// handler can read it again. Bodies are capped at the maximum value size plus
+// bodyFraming, or maxBufferedBody when values are unlimited.
+func bufferBody(r *http.Request) ([]byte, error) {
+	limit := int64(maxBufferedBody)
+	if size := cache.MaxValueSize(); size > 0 {
+		limit = int64(size) + bodyFraming
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import "container/list"
+
+// LIRS entry states
+const (
+	lirsLIR         = iota // Low inter-reference recency, always resident
+	lirsHIR                // High inter-reference recency, resident
+	lirsNonResident        // HIR whose value was evicted, kept for its history
+)
+
+// lirsPolicy implements LIRS (Low Inter-reference Recency Set). Most of the
+// capacity holds LIR items, those re-referenced within a short distance;
+// only a small set of resident HIR items is evicted from. A one-off scan
+// passes through the HIR queue without displacing the LIR set.
+type lirsPolicy struct {
+	hirPercent  float64
+	stackFactor float64
+	lirLimit    int
+	hirLimit    int
+	nrLimit     int // Non-resident entries kept in the stack
+
+	entries  map[string]*lirsEntry
+	stack    *list.List // S: recency stack, most recent at the front
+	queue    *list.List // Q: resident HIR items, next victim at the front
+	nonRes   *list.List // Non-resident entries, oldest at the front
+	lirCount int
+}
+
+type lirsEntry struct {
+	key   string
+	state int
+	sEle  *list.Element // Position in the stack, nil if pruned
+	qEle  *list.Element // Position in the queue if resident HIR
+	nrEle *list.Element // Position in nonRes if non-resident
+}
+
+// LIRSStats reports the sizes of the LIRS sets and their limits
+type LIRSStats struct {
+	LIR         int `json:"lir"`
+	HIR         int `json:"hir_resident"`
+	NonResident int `json:"hir_nonresident"`
+	LIRLimit    int `json:"lir_limit"`
+	HIRLimit    int `json:"hir_limit"`
+	StackSize   int `json:"stack_size"`
+}
+
+// newLIRSPolicy creates a LIRS policy reserving hirPercent of capacity for
+// resident HIR items and remembering up to stackFactor*capacity
+// non-resident ones
+func newLIRSPolicy(capacity int, hirPercent, stackFactor float64) *lirsPolicy {
+	if hirPercent <= 0 || hirPercent >= 100 {
+		hirPercent = 1
+	}
+	if stackFactor <= 0 {
+		stackFactor = 2
+	}
+	p := &lirsPolicy{
+		hirPercent:  hirPercent,
+		stackFactor: stackFactor,
+		entries:     make(map[string]*lirsEntry),
+		stack:       list.New(),
+		queue:       list.New(),
+		nonRes:      list.New(),
+	}
+	p.resize(capacity)
+	return p
+}
+
+func (p *lirsPolicy) name() string { return "lirs" }
+
+func (p *lirsPolicy) added(item *CacheItem) {
+	e, ok := p.entries[item.Key]
+	switch {
+	case ok && e.state == lirsNonResident && e.sEle != nil:
+		// re-referenced while its history is still in the stack: promote
+		p.forgetNonResident(e)
+		p.makeLIR(e)
+	case p.lirCount < p.lirLimit:
+		if ok {
+			p.forgetNonResident(e)
+		} else {
+			e = &lirsEntry{key: item.Key}
+			p.entries[item.Key] = e
+		}
+		e.state = lirsLIR
+		p.lirCount++
+		p.toStackTop(e)
+	default:
+		if ok {
+			p.forgetNonResident(e)
+		} else {
+			e = &lirsEntry{key: item.Key}
+			p.entries[item.Key] = e
+		}
+		e.state = lirsHIR
+		p.toStackTop(e)
+		e.qEle = p.queue.PushBack(e)
+	}
+}
+
+func (p *lirsPolicy) accessed(item *CacheItem) {
+	e, ok := p.entries[item.Key]
+	if !ok {
+		return
+	}
+	switch e.state {
+	case lirsLIR:
+		p.toStackTop(e)
+		p.prune()
+	case lirsHIR:
+		if e.sEle != nil {
+			p.queue.Remove(e.qEle)
+			e.qEle = nil
+			p.makeLIR(e)
+			return
+		}
+		p.toStackTop(e)
+		p.queue.MoveToBack(e.qEle)
+	}
+}
+
+func (p *lirsPolicy) removed(item *CacheItem, reason RemovalReason) {
+	e, ok := p.entries[item.Key]
+	if !ok {
+		return
+	}
+	if e.qEle != nil {
+		p.queue.Remove(e.qEle)
+		e.qEle = nil
+	}
+	if reason == RemovalEvicted && e.state == lirsHIR && e.sEle != nil {
+		// keep its recency so a quick re-reference promotes it
+		e.state = lirsNonResident
+		e.nrEle = p.nonRes.PushBack(e)
+		p.trimNonResident()
+		return
+	}
+
+	if e.state == lirsLIR {
+		p.lirCount--
+	}
+	if e.sEle != nil {
+		p.stack.Remove(e.sEle)
+	}
+	delete(p.entries, item.Key)
+	p.prune()
+}
+
+func (p *lirsPolicy) victim(incoming string) string {
+	for ele := p.queue.Front(); ele != nil; ele = ele.Next() {
+		if key := ele.Value.(*lirsEntry).key; key != incoming || ele.Next() == nil {
+			return key
+		}
+	}
+	// no resident HIR items, e.g. after shrinking: take the oldest LIR
+	for ele := p.stack.Back(); ele != nil; ele = ele.Prev() {
+		if e := ele.Value.(*lirsEntry); e.state == lirsLIR {
+			return e.key
+		}
+	}
+	return incoming
+}
+
+func (p *lirsPolicy) resize(capacity int) {
+	p.hirLimit = int(float64(capacity) * p.hirPercent / 100)
+	if p.hirLimit < 1 {
+		p.hirLimit = 1
+	}
+	p.lirLimit = capacity - p.hirLimit
+	if p.lirLimit < 1 {
+		p.lirLimit = 1
+	}
+	p.nrLimit = int(float64(capacity) * p.stackFactor)
+	for p.lirCount > p.lirLimit {
+		p.demoteBottomLIR()
+	}
+	p.trimNonResident()
+}
+
+func (p *lirsPolicy) stats() interface{} {
+	return LIRSStats{
+		LIR:         p.lirCount,
+		HIR:         p.queue.Len(),
+		NonResident: p.nonRes.Len(),
+		LIRLimit:    p.lirLimit,
+		HIRLimit:    p.hirLimit,
+		StackSize:   p.stack.Len(),
+	}
+}
+
+func (p *lirsPolicy) needsOrder() bool { return true }
+
+// makeLIR turns e into a LIR entry at the top of the stack, demoting the
+// bottom LIR entry to keep the LIR set within its limit
+func (p *lirsPolicy) makeLIR(e *lirsEntry) {
+	e.state = lirsLIR
+	p.lirCount++
+	p.toStackTop(e)
+	for p.lirCount > p.lirLimit {
+		p.demoteBottomLIR()
+	}
+}
+
+// demoteBottomLIR turns the LIR entry at the bottom of the stack into a
+// resident HIR entry at the end of the queue
+func (p *lirsPolicy) demoteBottomLIR() {
+	p.prune()
+	ele := p.stack.Back()
+	if ele == nil {
+		return
+	}
+	e := ele.Value.(*lirsEntry)
+	p.stack.Remove(ele)
+	e.sEle = nil
+	e.state = lirsHIR
+	e.qEle = p.queue.PushBack(e)
+	p.lirCount--
+	p.prune()
+}
+
+// prune removes HIR entries from the bottom of the stack until a LIR entry
+// is at the bottom; pruned non-resident entries are forgotten
+func (p *lirsPolicy) prune() {
+	for ele := p.stack.Back(); ele != nil; ele = p.stack.Back() {
+		e := ele.Value.(*lirsEntry)
+		if e.state == lirsLIR {
+			return
+		}
+		p.stack.Remove(ele)
+		e.sEle = nil
+		if e.state == lirsNonResident {
+			p.forgetNonResident(e)
+			delete(p.entries, e.key)
+		}
+	}
+}
+
+// trimNonResident forgets the oldest non-resident entries beyond the limit
+func (p *lirsPolicy) trimNonResident() {
+	for p.nonRes.Len() > p.nrLimit {
+		e := p.nonRes.Front().Value.(*lirsEntry)
+		p.forgetNonResident(e)
+		if e.sEle != nil {
+			p.stack.Remove(e.sEle)
+		}
+		delete(p.entries, e.key)
+	}
+}
+
+// forgetNonResident drops e from the non-resident list, if it is on it
+func (p *lirsPolicy) forgetNonResident(e *lirsEntry) {
+	if e.nrEle != nil {
+		p.nonRes.Remove(e.nrEle)
+		e.nrEle = nil
+	}
+}
+
+// toStackTop moves or pushes e to the top of the stack
+func (p *lirsPolicy) toStackTop(e *lirsEntry) {
+	if e.sEle != nil {
+		p.stack.MoveToFront(e.sEle)
+		return
+	}
+	e.sEle = p.stack.PushFront(e)
+}
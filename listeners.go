@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// shutdownTimeout bounds how long listeners get to drain on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// listener is a protocol server run by the listenerManager
+type listener interface {
+	Name() string
+	Listen() error
+	Serve() error
+	Shutdown(ctx context.Context) error
+}
+
+// httpListener serves the HTTP API
+type httpListener struct {
+	name string
+	srv  *http.Server
+	ln   net.Listener
+}
+
+// newHTTPListener creates an HTTP listener bound to addr, or to the socket
+// passed by systemd under the listener's name if there is one
+func newHTTPListener(name, addr string, handler http.Handler) *httpListener {
+	return &httpListener{name: name, srv: &http.Server{Addr: addr, Handler: handler}, ln: activatedListener(name)}
+}
+
+func (l *httpListener) Name() string { return l.name }
+
+func (l *httpListener) Listen() error {
+	if l.ln != nil {
+		logrus.Infof("%s listener using activated socket %s", l.name, l.ln.Addr())
+		return nil
+	}
+	addr := l.srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	l.ln = ln
+	return nil
+}
+
+// file returns a duplicate of the bound socket, for passing to another process
+func (l *httpListener) file() (*os.File, error) {
+	fl, ok := l.ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, errors.New(l.name + " listener socket cannot be passed on")
+	}
+	return fl.File()
+}
+
+func (l *httpListener) Serve() error {
+	err := l.srv.Serve(limitListener{l.ln})
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (l *httpListener) Shutdown(ctx context.Context) error {
+	return l.srv.Shutdown(ctx)
+}
+
+// listenerManager runs a set of listeners concurrently with a shared shutdown
+type listenerManager struct {
+	listeners []listener
+}
+
+// Add registers a listener to be started by Run
+func (m *listenerManager) Add(l listener) {
+	m.listeners = append(m.listeners, l)
+}
+
+// Run binds all listeners, starts serving and blocks until ctx is done or
+// one of them fails, then shuts every listener down. systemd is notified
+// once every listener is bound and again when shutdown begins.
+func (m *listenerManager) Run(ctx context.Context) error {
+	if len(m.listeners) == 0 {
+		return errors.New("no listeners enabled")
+	}
+	for _, l := range m.listeners {
+		if err := l.Listen(); err != nil {
+			return err
+		}
+	}
+
+	errc := make(chan error, len(m.listeners))
+	for _, l := range m.listeners {
+		l := l
+		go func() {
+			logrus.Infof("%s listener starting", l.Name())
+			if err := l.Serve(); err != nil {
+				errc <- err
+			}
+		}()
+	}
+
+	sdNotify("READY=1")
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-errc:
+	}
+	sdNotify("STOPPING=1")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, l := range m.listeners {
+		wg.Add(1)
+		go func(l listener) {
+			defer wg.Done()
+			if err := l.Shutdown(shutdownCtx); err != nil {
+				logrus.Errorf("%s listener shutdown: %v", l.Name(), err)
+			}
+		}(l)
+	}
+	wg.Wait()
+	return runErr
+}
@@ -2,135 +2,1579 @@ package main
 
 import (
 	"container/list"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"lrucache/clock"
+
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNotFound is returned when an operation requires an existing key
+	ErrNotFound = errors.New("key not found")
+	// ErrValueTooLarge is returned when a value would exceed the maximum value size
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrValueMismatch is returned when a conditional operation finds another value
+	ErrValueMismatch = errors.New("value does not match")
+)
+
+// Expiration sentinels for the exp argument of Set and friends
+const (
+	// NoExpiration stores an item that never expires
+	NoExpiration time.Duration = -1
+	// DefaultExpiration uses the cache's default TTL, see SetDefaultExpiration
+	DefaultExpiration time.Duration = 0
 )
 
+// maxGetAllLimit caps how many entries a single /getall returns
+const maxGetAllLimit = 10000
+
 // CacheItem represents an item stored in the cache
 type CacheItem struct {
 	Key   string
 	Value string
-	Exp   time.Time // Expiration time for the cache item
+	Exp   time.Time // Expiration time for the cache item, zero if it never expires
+	Cost  float64   // Cost of recomputing the value, for cost-aware eviction; 0 means 1
+
+	onRemove func(RemovalReason) // Called once the item leaves the cache, if set
+	hits     uint64              // Reads that found the item
+	accessed time.Time           // Last read, zero if never read
+	created  time.Time           // When the current value was written, zero if unknown
+	// Cache-Control directives sent with /get hits, empty if none were given
+	cacheControl string
+	dependsOn    []string // Keys whose writes and deletions invalidate the item
+	written      uint64   // Write token of the current value, 0 if unknown
+}
+
+// ItemInfo describes the cache entry behind a value
+type ItemInfo struct {
+	CreatedAt time.Time     // Zero if unknown, e.g. for restored entries
+	Age       time.Duration // Since CreatedAt
+	TTL       time.Duration // Remaining, NoExpiration if the entry never expires
+	Hits      uint64        // Reads that found the entry, including this one
+	// Cache-Control directives stored with the value, empty if none
+	CacheControl string
+	Token        uint64 // Write token of the value, 0 if unknown
+}
+
+// info describes the item at now
+func (i *CacheItem) info(now time.Time) ItemInfo {
+	info := ItemInfo{CreatedAt: i.created, TTL: NoExpiration, Hits: i.hits, CacheControl: i.cacheControl, Token: i.written}
+	if !i.created.IsZero() {
+		info.Age = now.Sub(i.created)
+	}
+	if !i.Exp.IsZero() {
+		info.TTL = i.Exp.Sub(now)
+	}
+	return info
+}
+
+// expired reports whether the item's expiration time has passed at now
+func (i *CacheItem) expired(now time.Time) bool {
+	return !i.Exp.IsZero() && now.After(i.Exp)
 }
 
 // LRUCache represents the LRU cache
 type LRUCache struct {
 	capacity int
+	maxSize  int // Maximum value size in bytes, 0 for unlimited
 	items    map[string]*list.Element
 	ll       *list.List
 	mu       sync.Mutex
+	events   *eventBroker
+	shadows  *shadowSet
+	stats    Stats
+	patterns *patternTracker
+	latency  *latencyTracker
+	// contention times lock waits when set; see lock
+	contention *contentionDetector
+	evicted    *evictionFilter
+	expired    *evictionFilter // Recently expired keys, rotated like evicted
+	token      uint64          // Incremented on every write, for read-your-writes checks
+	clock      clock.Clock
+	pending    []func() // Removal callbacks to run once c.mu is released
+	policy     evictionPolicy
+	rand       *rand.Rand    // Randomness for eviction and XFetch, seedable for simulations
+	defaultTTL time.Duration // Used for DefaultExpiration; 0 never expires
+	oplog      *opRecorder   // Records every operation when set
+	slowlog    *slowLog
+	lockWait   time.Duration // How long the current lock holder waited, when timed; see lock
+	// Keys of the items depending on each key, see linkDependencies
+	dependents map[string]map[string]struct{}
 }
 
 var cache *LRUCache // Declare cache as a global variable
 
 // NewLRUCache creates a new LRUCache with the given capacity
 func NewLRUCache(capacity int) *LRUCache {
+	return NewLRUCacheWithClock(capacity, clock.Real{})
+}
+
+// NewLRUCacheWithClock creates a new LRUCache whose expiry logic reads time from clk
+func NewLRUCacheWithClock(capacity int, clk clock.Clock) *LRUCache {
+	ll := list.New()
 	return &LRUCache{
 		capacity: capacity,
 		items:    make(map[string]*list.Element),
-		ll:       list.New(),
+		ll:       ll,
+		policy:   &lruPolicy{ll: ll},
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		events:   newEventBroker(),
+		shadows:  newShadowSet(nil),
+		patterns: newPatternTracker(),
+		latency:  newLatencyTracker(),
+		slowlog:  newSlowLog(),
+		clock:    clk,
+		evicted:  newEvictionFilter(capacity),
+		expired:  newEvictionFilter(capacity),
 	}
 }
 
 // Get retrieves the value associated with the key from the cache
 func (c *LRUCache) Get(key string) (string, bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpGet, time.Now(), key)
+
+	if item := c.get(key); item != nil {
+		return item.Value, true
+	}
+	return "", false
+}
+
+// GetWithInfo is like Get, but also describes the entry found
+func (c *LRUCache) GetWithInfo(key string) (string, ItemInfo, bool) {
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpGet, time.Now(), key)
+
+	if item := c.get(key); item != nil {
+		return item.Value, item.info(c.clock.Now()), true
+	}
+	return "", ItemInfo{}, false
+}
+
+// GetXFetch is like GetWithInfo, but implements XFetch probabilistic early
+// expiration: a hit reports early when now - cost*beta*ln(rand()) is past
+// the expiry, taking the item's cost as its recompute time in milliseconds.
+// The chance grows as expiry nears and with the cost, so one caller
+// refreshes a hot key ahead of time instead of all of them at expiry. The
+// entry itself is left in place for other readers.
+func (c *LRUCache) GetXFetch(key string, beta float64) (value string, info ItemInfo, ok bool, early bool) {
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpGet, time.Now(), key)
+
+	item := c.get(key)
+	if item == nil {
+		return "", ItemInfo{}, false, false
+	}
+	delta := time.Duration(item.Cost * float64(time.Millisecond))
+	if delta > 0 && beta > 0 {
+		gap := time.Duration(-float64(delta) * beta * math.Log(c.rand.Float64()))
+		early = !item.Exp.IsZero() && !c.clock.Now().Add(gap).Before(item.Exp)
+	}
+	return item.Value, item.info(c.clock.Now()), true, early
+}
 
+// get looks up an unexpired item and records the lookup; the caller must hold c.mu
+func (c *LRUCache) get(key string) *CacheItem {
 	if ele, ok := c.items[key]; ok {
-		c.ll.MoveToFront(ele)
+		c.touch(ele)
 		item := ele.Value.(*CacheItem)
-		if time.Now().After(item.Exp) {
-			c.removeElement(ele)
-			return "", false
+		if item.expired(c.clock.Now()) {
+			c.record(Op{Op: "get", Key: key, Found: &miss})
+			c.removeElement(ele, RemovalExpired)
+			c.recordGet(key, false)
+			return nil
 		}
-		return item.Value, true
+		c.record(Op{Op: "get", Key: key, Found: &hit})
+		c.recordGet(key, true)
+		c.policy.accessed(item)
+		item.hits++
+		item.accessed = c.clock.Now()
+		return item
 	}
-	return "", false
+	c.record(Op{Op: "get", Key: key, Found: &miss})
+	c.recordGet(key, false)
+	return nil
+}
+
+// Lookup results, addressable for recorded ops
+var hit, miss = true, false
+
+// MGet retrieves the values of all found keys in one locked pass. If touch is
+// positive, the expiration of every returned key is reset to now+touch.
+func (c *LRUCache) MGet(keys []string, touch time.Duration) map[string]string {
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpMGet, time.Now(), keys...)
+
+	now := c.clock.Now()
+	values := make(map[string]string, len(keys))
+	ttl := durationMs(touch)
+	for _, key := range keys {
+		ele, ok := c.items[key]
+		if !ok {
+			c.record(Op{Op: "mget", Key: key, TTL: ttl, Found: &miss})
+			c.recordGet(key, false)
+			continue
+		}
+		c.touch(ele)
+		item := ele.Value.(*CacheItem)
+		if item.expired(now) {
+			c.record(Op{Op: "mget", Key: key, TTL: ttl, Found: &miss})
+			c.removeElement(ele, RemovalExpired)
+			c.recordGet(key, false)
+			continue
+		}
+		c.record(Op{Op: "mget", Key: key, TTL: ttl, Found: &hit})
+		if touch > 0 {
+			item.Exp = now.Add(touch)
+		}
+		c.recordGet(key, true)
+		c.policy.accessed(item)
+		item.hits++
+		item.accessed = now
+		values[key] = item.Value
+	}
+	return values
+}
+
+// Scan returns up to count unexpired keys with the given prefix that sort after
+// the key after. Iterating in key order means every key that exists for the
+// whole scan is returned exactly once, regardless of concurrent writes.
+func (c *LRUCache) Scan(prefix, after string, count int) (keys []string, more bool) {
+	c.lock()
+	now := c.clock.Now()
+	matched := []string{}
+	for key, ele := range c.items {
+		if key > after && strings.HasPrefix(key, prefix) && !ele.Value.(*CacheItem).expired(now) {
+			matched = append(matched, key)
+		}
+	}
+	c.unlock()
+
+	sort.Strings(matched)
+	if len(matched) > count {
+		return matched[:count], true
+	}
+	return matched, false
+}
+
+// GetMatching returns the unexpired entries whose keys match the glob
+// pattern, as in path.Match, up to limit in key order; more reports whether
+// there were others. It peeks, leaving recency and hit counts alone. The
+// pattern must already be known to be valid.
+func (c *LRUCache) GetMatching(pattern string, limit int) (values map[string]string, more bool) {
+	// Only keys starting with the literal part of the pattern can match
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+
+	c.lock()
+	defer c.unlock()
+
+	now := c.clock.Now()
+	matched := []string{}
+	for key, ele := range c.items {
+		if !strings.HasPrefix(key, prefix) || ele.Value.(*CacheItem).expired(now) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, key); ok {
+			matched = append(matched, key)
+		}
+	}
+
+	sort.Strings(matched)
+	if len(matched) > limit {
+		matched, more = matched[:limit], true
+	}
+	values = make(map[string]string, len(matched))
+	for _, key := range matched {
+		values[key] = c.items[key].Value.(*CacheItem).Value
+	}
+	return values, more
+}
+
+// WriteToken returns the token of the most recent write applied to the cache
+func (c *LRUCache) WriteToken() uint64 {
+	c.lock()
+	defer c.unlock()
+	return c.token
 }
 
-// Set adds or updates a value in the cache with the specified expiration time
-func (c *LRUCache) Set(key string, value string, exp time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// Set adds or updates a value in the cache with the specified expiration time.
+// It returns the write token assigned to this write.
+func (c *LRUCache) Set(key string, value string, exp time.Duration) (uint64, error) {
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpSet, time.Now(), key)
 
+	if c.tooLarge(value) {
+		return 0, ErrValueTooLarge
+	}
+	return c.set(key, value, exp, entryOptions{}), nil
+}
+
+// SetWithCallback is like Set, but onRemove is called with the reason once
+// the entry leaves the cache: expired, evicted, replaced by another write to
+// key, or deleted. It runs after the cache lock is released, so it may use
+// the cache.
+func (c *LRUCache) SetWithCallback(key string, value string, exp time.Duration, onRemove func(reason RemovalReason)) (uint64, error) {
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpSet, time.Now(), key)
+
+	if c.tooLarge(value) {
+		return 0, ErrValueTooLarge
+	}
+	return c.set(key, value, exp, entryOptions{onRemove: onRemove}), nil
+}
+
+// SetWithCost is like Set, but records what recomputing the value costs (for
+// example in milliseconds), which cost-aware eviction policies weigh against
+// the value's size
+func (c *LRUCache) SetWithCost(key string, value string, exp time.Duration, cost float64) (uint64, error) {
+	return c.SetWithOptions(key, value, exp, SetOptions{Cost: cost})
+}
+
+// SetOptions holds the optional metadata of a write
+type SetOptions struct {
+	Cost float64 // See SetWithCost
+	// Cache-Control directives for HTTP caches in front of the server
+	CacheControl string
+	// Keys whose writes and deletions invalidate this entry, transitively
+	DependsOn []string
+}
+
+// SetWithOptions is like Set, but stores the given metadata with the value
+func (c *LRUCache) SetWithOptions(key string, value string, exp time.Duration, opts SetOptions) (uint64, error) {
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpSet, time.Now(), key)
+
+	if c.tooLarge(value) {
+		return 0, ErrValueTooLarge
+	}
+	return c.set(key, value, exp, entryOptions{cost: opts.Cost, cacheControl: opts.CacheControl, dependsOn: opts.DependsOn}), nil
+}
+
+// SetItems stores a batch of items under a single lock acquisition, keeping
+// their absolute expiration times. It returns how many were stored and how
+// many had already expired; the rest exceeded the maximum value size.
+func (c *LRUCache) SetItems(items []CacheItem) (stored, expired int) {
+	c.lock()
+	defer c.unlock()
+
+	now := c.clock.Now()
+	for _, item := range items {
+		ttl := NoExpiration
+		if !item.Exp.IsZero() {
+			if ttl = item.Exp.Sub(now); ttl <= 0 {
+				expired++
+				continue
+			}
+		}
+		if c.tooLarge(item.Value) {
+			continue
+		}
+		c.set(item.Key, item.Value, ttl, entryOptions{cost: item.Cost})
+		stored++
+	}
+	return stored, expired
+}
+
+// Add stores a value only if the key is absent or expired, reporting whether it was stored
+func (c *LRUCache) Add(key string, value string, exp time.Duration) bool {
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpSet, time.Now(), key)
+
+	if ele, ok := c.items[key]; ok && !ele.Value.(*CacheItem).expired(c.clock.Now()) {
+		return false
+	}
+	c.set(key, value, exp, entryOptions{})
+	return true
+}
+
+// GetSet atomically replaces the value associated with the key and returns
+// the previous value, if there was an unexpired one
+func (c *LRUCache) GetSet(key string, value string, exp time.Duration) (string, bool, uint64, error) {
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpSet, time.Now(), key)
+
+	if c.tooLarge(value) {
+		return "", false, 0, ErrValueTooLarge
+	}
+	var prev string
+	var found bool
 	if ele, ok := c.items[key]; ok {
-		c.ll.MoveToFront(ele)
 		item := ele.Value.(*CacheItem)
+		if !item.expired(c.clock.Now()) {
+			prev, found = item.Value, true
+		}
+	}
+	return prev, found, c.set(key, value, exp, entryOptions{}), nil
+}
+
+// entryOptions holds the optional per-entry settings of a write
+type entryOptions struct {
+	onRemove     func(RemovalReason)
+	cost         float64
+	cacheControl string
+	dependsOn    []string
+}
+
+// SetDefaultExpiration sets the TTL used for writes passing
+// DefaultExpiration; zero or NoExpiration makes them never expire
+func (c *LRUCache) SetDefaultExpiration(ttl time.Duration) {
+	c.lock()
+	defer c.unlock()
+	c.defaultTTL = ttl
+}
+
+// expiry resolves the exp argument of a write to an expiration time, the
+// zero time for none, and the TTL it stands for, NoExpiration for none;
+// the caller must hold c.mu
+func (c *LRUCache) expiry(exp time.Duration) (time.Time, time.Duration) {
+	if exp == DefaultExpiration {
+		exp = c.defaultTTL
+	}
+	if exp <= 0 {
+		return time.Time{}, NoExpiration
+	}
+	return c.clock.Now().Add(exp), exp
+}
+
+// set adds or updates a value, replacing the entry's options with opts;
+// the caller must hold c.mu
+func (c *LRUCache) set(key string, value string, exp time.Duration, opts entryOptions) uint64 {
+	expAt, ttl := c.expiry(exp)
+	c.record(Op{Op: "set", Key: key, Value: value, TTL: durationMs(ttl), Cost: opts.cost})
+	c.shadows.set(key)
+	var reason RemovalReason
+	if ele, ok := c.items[key]; ok {
+		item := ele.Value.(*CacheItem)
+		c.unlinkDependencies(item)
+	}
+	c.invalidateDependents(key)
+	if ele, ok := c.items[key]; ok {
+		reason = RemovalReplaced
+		c.touch(ele)
+		item := ele.Value.(*CacheItem)
+		c.queueRemoval(item, RemovalReplaced)
 		item.Value = value
-		item.Exp = time.Now().Add(exp)
+		item.Exp = expAt
+		item.created = c.clock.Now()
+		item.Cost = opts.cost
+		item.onRemove = opts.onRemove
+		item.cacheControl = opts.cacheControl
+		c.linkDependencies(item, opts.dependsOn)
+		c.policy.accessed(item)
 	} else {
-		ele := c.ll.PushFront(&CacheItem{Key: key, Value: value, Exp: time.Now().Add(exp)})
-		c.items[key] = ele
+		item := &CacheItem{Key: key, Value: value, Exp: expAt, Cost: opts.cost, onRemove: opts.onRemove, cacheControl: opts.cacheControl, created: c.clock.Now()}
+		c.linkDependencies(item, opts.dependsOn)
+		c.items[key] = c.ll.PushFront(item)
+		c.policy.added(item)
 		if c.ll.Len() > c.capacity {
-			c.removeOldest()
+			c.evict(key)
 		}
 	}
+	c.notify(EventSet, key, reason)
+	c.token++
+	if ele, ok := c.items[key]; ok {
+		ele.Value.(*CacheItem).written = c.token
+	}
+	return c.token
 }
 
-// removeOldest removes the oldest item from the cache
-func (c *LRUCache) removeOldest() {
-	ele := c.ll.Back()
-	if ele != nil {
-		c.removeElement(ele)
+// Delete removes key from the cache, reporting whether it was present
+func (c *LRUCache) Delete(key string) bool {
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpDelete, time.Now(), key)
+
+	ele, ok := c.items[key]
+	if !ok {
+		return false
 	}
+	c.removeElement(ele, RemovalDeleted)
+	c.token++
+	return true
+}
+
+// DeleteIfEquals removes key only if its value is expected, so a lock or
+// token can only be released by its owner. It returns ErrNotFound if the key
+// is absent or expired and ErrValueMismatch if it holds another value.
+func (c *LRUCache) DeleteIfEquals(key, expected string) error {
+	return c.DeleteIf(key, func(value string) bool { return value == expected })
 }
 
-// removeElement removes the specified element from the cache
-func (c *LRUCache) removeElement(ele *list.Element) {
+// DeleteIf is like DeleteIfEquals, removing key only if match returns true
+// for its stored value. match runs with the cache lock held.
+func (c *LRUCache) DeleteIf(key string, match func(value string) bool) error {
+	c.lock()
+	defer c.unlock()
+	defer c.observe(OpDelete, time.Now(), key)
+
+	ele, ok := c.items[key]
+	if !ok {
+		return ErrNotFound
+	}
+	item := ele.Value.(*CacheItem)
+	if item.expired(c.clock.Now()) {
+		c.removeElement(ele, RemovalExpired)
+		return ErrNotFound
+	}
+	if !match(item.Value) {
+		return ErrValueMismatch
+	}
+	c.removeElement(ele, RemovalDeleted)
+	c.token++
+	return nil
+}
+
+// Pop atomically retrieves and removes the value associated with the key
+func (c *LRUCache) Pop(key string) (string, bool) {
+	c.lock()
+	defer c.unlock()
+
+	ele, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	item := ele.Value.(*CacheItem)
+	if item.expired(c.clock.Now()) {
+		c.removeElement(ele, RemovalExpired)
+		return "", false
+	}
+	c.removeElement(ele, RemovalDeleted)
+	c.token++
+	return item.Value, true
+}
+
+// DeleteMatching removes every key for which match returns true and returns the removed keys
+func (c *LRUCache) DeleteMatching(match func(key string) bool) []string {
+	c.lock()
+	defer c.unlock()
+
+	deleted := []string{}
+	for key, ele := range c.items {
+		if match(key) {
+			c.removeElement(ele, RemovalDeleted)
+			deleted = append(deleted, key)
+		}
+	}
+	if len(deleted) > 0 {
+		c.token++
+	}
+	return deleted
+}
+
+// ExpirePrefix changes the expiration of every unexpired key with prefix
+// for which allow returns true, either to now+ttl or, with extend, to its
+// current expiration plus ttl. A negative ttl without extend makes the keys
+// never expire; extending leaves keys without expiration unchanged. It
+// returns the number of keys updated.
+func (c *LRUCache) ExpirePrefix(prefix string, ttl time.Duration, extend bool, allow func(key string) bool) int {
+	c.lock()
+	defer c.unlock()
+
+	c.record(Op{Op: "expire_prefix", Key: prefix, TTL: durationMs(ttl), Extend: extend})
+	now := c.clock.Now()
+	updated := 0
+	for key, ele := range c.items {
+		item := ele.Value.(*CacheItem)
+		if !strings.HasPrefix(key, prefix) || item.expired(now) || !allow(key) {
+			continue
+		}
+		switch {
+		case extend:
+			if !item.Exp.IsZero() {
+				item.Exp = item.Exp.Add(ttl)
+			}
+		case ttl < 0:
+			item.Exp = time.Time{}
+		default:
+			item.Exp = now.Add(ttl)
+		}
+		updated++
+	}
+	if updated > 0 {
+		c.token++
+	}
+	return updated
+}
+
+// Resize changes the capacity of the cache, evicting the oldest items if it shrinks
+func (c *LRUCache) Resize(capacity int) {
+	c.lock()
+	defer c.unlock()
+
+	c.record(Op{Op: "resize", Capacity: capacity})
+	c.capacity = capacity
+	c.policy.resize(capacity)
+	for c.ll.Len() > c.capacity {
+		if !c.evict("") {
+			break // The policy has nothing left to offer
+		}
+	}
+}
+
+// SetMaxValueSize sets the maximum value size in bytes; 0 disables the limit
+func (c *LRUCache) SetMaxValueSize(size int) {
+	c.lock()
+	defer c.unlock()
+	c.maxSize = size
+}
+
+// tooLarge reports whether value exceeds the maximum value size, counting
+// the rejected write if it does
+func (c *LRUCache) tooLarge(value string) bool {
+	if c.maxSize > 0 && len(value) > c.maxSize {
+		c.stats.TooLarge++
+		return true
+	}
+	return false
+}
+
+// recordTooLarge counts a write rejected before reaching the cache because
+// its value exceeds the maximum value size
+func (c *LRUCache) recordTooLarge() {
+	c.lock()
+	defer c.unlock()
+	c.stats.TooLarge++
+}
+
+// MaxValueSize returns the maximum value size in bytes, 0 if unlimited
+func (c *LRUCache) MaxValueSize() int {
+	c.lock()
+	defer c.unlock()
+	return c.maxSize
+}
+
+// Append adds suffix to the end of an existing value, keeping its expiration time
+func (c *LRUCache) Append(key, suffix string) (uint64, error) {
+	return c.modify(key, func(value string) string { return value + suffix })
+}
+
+// Prepend adds prefix to the start of an existing value, keeping its expiration time
+func (c *LRUCache) Prepend(key, prefix string) (uint64, error) {
+	return c.modify(key, func(value string) string { return prefix + value })
+}
+
+// modify replaces an existing, unexpired value with fn(value)
+func (c *LRUCache) modify(key string, fn func(string) string) (uint64, error) {
+	c.lock()
+	defer c.unlock()
+
+	ele, ok := c.items[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	item := ele.Value.(*CacheItem)
+	if item.expired(c.clock.Now()) {
+		c.removeElement(ele, RemovalExpired)
+		return 0, ErrNotFound
+	}
+
+	value := fn(item.Value)
+	if c.tooLarge(value) {
+		return 0, ErrValueTooLarge
+	}
+	ttl := NoExpiration
+	if !item.Exp.IsZero() {
+		ttl = item.Exp.Sub(c.clock.Now())
+	}
+	c.record(Op{Op: "set", Key: key, Value: value, TTL: durationMs(ttl), Cost: item.Cost})
+	c.invalidateDependents(key)
+	c.touch(ele)
+	item.Value = value
+	item.created = c.clock.Now()
+	c.policy.accessed(item)
+	c.notify(EventSet, key, 0)
+	c.token++
+	item.written = c.token
+	return c.token, nil
+}
+
+// Capacity returns the maximum number of items the cache holds
+func (c *LRUCache) Capacity() int {
+	c.lock()
+	defer c.unlock()
+	return c.capacity
+}
+
+// Len returns the number of items currently in the cache
+func (c *LRUCache) Len() int {
+	c.lock()
+	defer c.unlock()
+	return c.ll.Len()
+}
+
+// Snapshot returns a point-in-time copy of the unexpired items, least
+// recently used first, along with the write token it reflects. Under a policy
+// that skips list ordering the items come in insertion order instead.
+func (c *LRUCache) Snapshot() ([]CacheItem, uint64) {
+	c.lock()
+	defer c.unlock()
+
+	now := c.clock.Now()
+	items := make([]CacheItem, 0, c.ll.Len())
+	for ele := c.ll.Back(); ele != nil; ele = ele.Prev() {
+		item := ele.Value.(*CacheItem)
+		if !item.expired(now) {
+			items = append(items, *item)
+		}
+	}
+	return items, c.token
+}
+
+// Restore inserts items, least recently used first, keeping their absolute
+// expiration times and dependencies. Restored items are not counted as sets or published.
+// The write token moves up to token, the one the items were taken at, so
+// clients holding a token from before still see their writes.
+func (c *LRUCache) Restore(items []CacheItem, token uint64) {
+	c.lock()
+	defer c.unlock()
+
+	for _, item := range items {
+		item := item
+		if ele, ok := c.items[item.Key]; ok {
+			c.ll.Remove(ele)
+			c.unlinkDependencies(ele.Value.(*CacheItem))
+			c.policy.removed(ele.Value.(*CacheItem), RemovalReplaced)
+		}
+		c.linkDependencies(&item, item.dependsOn)
+		c.items[item.Key] = c.ll.PushFront(&item)
+		c.policy.added(&item)
+		if c.ll.Len() > c.capacity {
+			c.evict(item.Key)
+		}
+	}
+	if token > c.token {
+		c.token = token
+	} else {
+		c.token++
+	}
+}
+
+// Reasons a lookup missed, as reported by MissReason
+const (
+	MissNotFound = "not_found"
+	MissExpired  = "expired"
+	MissEvicted  = "evicted"
+	// Reported by /get?xfetch when a hit is treated as expired early
+	MissEarlyExpired = "early_expired"
+)
+
+// MissReason explains a miss on key: whether it recently expired, was
+// recently evicted for capacity, or is simply unknown. Like
+// WasRecentlyEvicted it is probabilistic and only covers recent removals.
+func (c *LRUCache) MissReason(key string) string {
+	switch {
+	case c.expired.contains(key):
+		return MissExpired
+	case c.evicted.contains(key):
+		return MissEvicted
+	default:
+		return MissNotFound
+	}
+}
+
+// WasRecentlyEvicted reports whether key was probably evicted for capacity
+// recently. False positives are possible, false negatives are not.
+func (c *LRUCache) WasRecentlyEvicted(key string) bool {
+	return c.evicted.contains(key)
+}
+
+// recordGet counts a lookup of key; the caller must hold c.mu
+func (c *LRUCache) recordGet(key string, hit bool) {
+	c.shadows.get(key)
+	c.patterns.recordGet(key, hit)
+	if hit {
+		c.stats.Hits++
+	} else {
+		c.stats.Misses++
+	}
+}
+
+// notify counts a change to key and publishes it as an event, along with the
+// reason the previous value went away, if it did; the caller must hold c.mu
+func (c *LRUCache) notify(eventType, key string, reason RemovalReason) {
+	switch eventType {
+	case EventSet:
+		c.stats.Sets++
+		if reason == RemovalReplaced {
+			c.stats.Replacements++
+		}
+	case EventDelete:
+		if reason == RemovalInvalidated {
+			c.stats.Invalidations++
+		} else {
+			c.stats.Deletes++
+		}
+	case EventEvict:
+		c.stats.Evictions++
+		c.patterns.recordEviction(key)
+	case EventExpire:
+		c.stats.Expirations++
+		c.expired.add(key)
+	}
+	ev := Event{Type: eventType, Key: key}
+	if reason != 0 {
+		ev.Reason = reason.String()
+	}
+	c.events.publish(ev)
+}
+
+// evict removes the item chosen by the eviction policy. incoming is the key
+// just inserted, if any, which the policy only picks as a last resort. It
+// reports whether an item was removed.
+func (c *LRUCache) evict(incoming string) bool {
+	ele, ok := c.items[c.policy.victim(incoming)]
+	if ok {
+		c.evicted.add(ele.Value.(*CacheItem).Key)
+		c.removeElement(ele, RemovalEvicted)
+	}
+	return ok
+}
+
+// touch moves ele to the front of the recency list unless the policy does
+// without it; the caller must hold c.mu
+func (c *LRUCache) touch(ele *list.Element) {
+	if c.policy.needsOrder() {
+		c.ll.MoveToFront(ele)
+	}
+}
+
+// removeElement removes the specified element from the cache, publishing the
+// removal and queueing its callback with reason; the caller must hold c.mu
+func (c *LRUCache) removeElement(ele *list.Element, reason RemovalReason) {
 	c.ll.Remove(ele)
 	item := ele.Value.(*CacheItem)
 	delete(c.items, item.Key)
+	if reason == RemovalDeleted || reason == RemovalInvalidated {
+		c.record(Op{Op: "delete", Key: item.Key})
+	}
+	c.unlinkDependencies(item)
+	c.policy.removed(item, reason)
+	if reason != RemovalEvicted {
+		c.shadows.remove(item.Key)
+	}
+	c.notify(reason.eventType(), item.Key, reason)
+	c.queueRemoval(item, reason)
+	if reason == RemovalDeleted || reason == RemovalInvalidated {
+		c.invalidateDependents(item.Key)
+	}
 }
 
 // handleSet handles the HTTP POST request to set a value in the cache
 func handleSet(w http.ResponseWriter, r *http.Request) {
 	type SetRequest struct {
-		Key   string `json:"key"`
-		Value string `json:"value"`
-		Exp   int    `json:"exp"`
+		Key   string  `json:"key"`
+		Value string  `json:"value"`
+		Exp   TTL     `json:"exp"`  // Omitted uses the default TTL, negative never expires
+		Cost  float64 `json:"cost"` // Recompute cost in ms, for cost-aware eviction and XFetch
+		// Cache-Control directives sent with /get hits, with max-age capped by the remaining TTL
+		CacheControl string `json:"cache_control"`
+		// Keys whose writes and deletions invalidate this entry, transitively
+		DependsOn []string `json:"depends_on"`
 	}
 
 	var req SetRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeDecodeError(w, err)
+		return
+	}
+	if !canonicalizeKeys(w, &req.Key) {
+		return
+	}
+	for i := range req.DependsOn {
+		if !canonicalizeKeys(w, &req.DependsOn[i]) {
+			return
+		}
+	}
+	if !keyAllowed(r, req.Key, AccessWrite) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !validCacheControl(req.CacheControl) {
+		http.Error(w, "Invalid cache_control", http.StatusBadRequest)
+		return
+	}
+	if errs := schemas.validate(req.Key, req.Value); len(errs) > 0 {
+		writeSchemaErrors(w, errs)
+		return
+	}
+
+	stored, err := transforms.encode(req.Key, req.Value)
+	if err != nil {
+		http.Error(w, "Value transform failed", http.StatusInternalServerError)
+		return
+	}
+
+	if !admitWrite(w) {
 		return
 	}
 
-	expiration := time.Duration(req.Exp) * time.Second
-	cache.Set(req.Key, req.Value, expiration)
+	expiration := time.Duration(req.Exp)
+	token, err := cache.SetWithOptions(req.Key, stored, expiration, SetOptions{Cost: req.Cost, CacheControl: req.CacheControl, DependsOn: req.DependsOn})
+	if errors.Is(err, ErrValueTooLarge) {
+		writePressureHeaders(w)
+		http.Error(w, "Value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]uint64{"token": token})
 }
 
 // handleGet handles the HTTP GET request to retrieve a value from the cache
 func handleGet(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
+	if !canonicalizeKeys(w, &key) {
+		return
+	}
+	if !keyAllowed(r, key, AccessRead) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if minToken := r.URL.Query().Get("min_token"); minToken != "" {
+		token, err := strconv.ParseUint(minToken, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid min_token", http.StatusBadRequest)
+			return
+		}
+		if cache.WriteToken() < token {
+			http.Error(w, "Write token not yet applied", http.StatusPreconditionFailed)
+			return
+		}
+	}
 
-	value, ok := cache.Get(key)
+	var value string
+	var info ItemInfo
+	var ok, early bool
+	if v := r.URL.Query().Get("xfetch"); v != "" {
+		beta, err := strconv.ParseFloat(v, 64)
+		if err != nil || beta < 0 {
+			http.Error(w, "Invalid xfetch", http.StatusBadRequest)
+			return
+		}
+		value, info, ok, early = cache.GetXFetch(key, beta)
+	} else {
+		value, info, ok = cache.GetWithInfo(key)
+	}
+	if early {
+		// this caller should recompute; the entry stays for everyone else
+		writeMiss(w, MissEarlyExpired)
+		return
+	}
 	if !ok {
+		writeMiss(w, cache.MissReason(key))
+		return
+	}
+	value, err := transforms.decode(key, value)
+	if err != nil {
+		http.Error(w, "Value transform failed", http.StatusInternalServerError)
+		return
+	}
+
+	writeItemInfo(w, info)
+	json.NewEncoder(w).Encode(map[string]string{"value": value})
+}
+
+// writeItemInfo sets the entry metadata headers of a hit. X-Cache-TTL-Remaining
+// is in seconds, -1 if the entry never expires; X-Cache-Token is the token of
+// the write that stored the value, for /txn checks. Age, X-Cache-Created-At
+// and X-Cache-Token are left out for entries whose write is unknown.
+func writeItemInfo(w http.ResponseWriter, info ItemInfo) {
+	ttl := "-1"
+	if info.TTL != NoExpiration {
+		ttl = strconv.FormatFloat(max(info.TTL, 0).Seconds(), 'f', 3, 64)
+	}
+	w.Header().Set("X-Cache-TTL-Remaining", ttl)
+	w.Header().Set("X-Cache-Hit-Count", strconv.FormatUint(info.Hits, 10))
+	if info.Token != 0 {
+		w.Header().Set("X-Cache-Token", strconv.FormatUint(info.Token, 10))
+	}
+	if !info.CreatedAt.IsZero() {
+		w.Header().Set("X-Cache-Created-At", info.CreatedAt.UTC().Format(time.RFC3339Nano))
+		w.Header().Set("Age", strconv.FormatInt(int64(info.Age/time.Second), 10))
+	}
+	if info.CacheControl != "" {
+		writeCacheControl(w, info)
+	}
+}
+
+// writeMiss answers a lookup with 404 and the reason it missed
+func writeMiss(w http.ResponseWriter, reason string) {
+	w.Header().Set("X-Cache-Miss-Hint", reason)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Key not found", "miss_reason": reason})
+}
+
+// handleAppend handles the HTTP POST request to append to an existing value
+func handleAppend(w http.ResponseWriter, r *http.Request) {
+	handleModify(w, r, cache.Append)
+}
+
+// handlePrepend handles the HTTP POST request to prepend to an existing value
+func handlePrepend(w http.ResponseWriter, r *http.Request) {
+	handleModify(w, r, cache.Prepend)
+}
+
+// handleModify decodes a key/value request and applies op to the cache.
+// Keys with transforms or a schema are refused, as the result would be
+// stored without going through them.
+func handleModify(w http.ResponseWriter, r *http.Request, op func(key, value string) (uint64, error)) {
+	type ModifyRequest struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+
+	var req ModifyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !canonicalizeKeys(w, &req.Key) {
+		return
+	}
+	if !keyAllowed(r, req.Key, AccessWrite) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if transforms.has(req.Key) {
+		http.Error(w, "Cannot modify transformed values in place", http.StatusConflict)
+		return
+	}
+	if schemas.has(req.Key) {
+		http.Error(w, "Cannot modify schema-validated values in place", http.StatusConflict)
+		return
+	}
+
+	token, err := op(req.Key, req.Value)
+	switch {
+	case errors.Is(err, ErrNotFound):
 		http.Error(w, "Key not found", http.StatusNotFound)
 		return
+	case errors.Is(err, ErrValueTooLarge):
+		writePressureHeaders(w)
+		http.Error(w, "Value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]uint64{"token": token})
+}
+
+// handleMGet handles the HTTP GET request to retrieve multiple values from the cache
+func handleMGet(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var touch time.Duration
+	if touchTTL := query.Get("touch_ttl"); touchTTL != "" {
+		var err error
+		if touch, err = parseTTL(touchTTL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if touch < 0 {
+			http.Error(w, "Invalid touch_ttl", http.StatusBadRequest)
+			return
+		}
+	}
+
+	keys := query["key"]
+	for i := range keys {
+		if !canonicalizeKeys(w, &keys[i]) {
+			return
+		}
+		if !keyAllowed(r, keys[i], AccessRead) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	values := cache.MGet(keys, touch)
+	for key, value := range values {
+		decoded, err := transforms.decode(key, value)
+		if err != nil {
+			http.Error(w, "Value transform failed", http.StatusInternalServerError)
+			return
+		}
+		values[key] = decoded
+	}
+
+	json.NewEncoder(w).Encode(map[string]map[string]string{"values": values})
+}
+
+// handleScan handles the HTTP GET request to iterate keys by prefix with a
+// cursor. Repeated part parameters instead select composite keys by their
+// leading parts.
+func handleScan(w http.ResponseWriter, r *http.Request) {
+	type ScanResponse struct {
+		Keys   []string `json:"keys"`
+		Cursor string   `json:"cursor"` // Empty once the scan is complete
+	}
+
+	query := r.URL.Query()
+
+	count := 100
+	if c := query.Get("count"); c != "" {
+		n, err := strconv.Atoi(c)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid count", http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+
+	after, err := base64.RawURLEncoding.DecodeString(query.Get("cursor"))
+	if err != nil {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	prefix := query.Get("prefix")
+	if parts := query["part"]; len(parts) > 0 {
+		if prefix != "" {
+			http.Error(w, "Cannot combine prefix and part", http.StatusBadRequest)
+			return
+		}
+		prefix = partsPrefix(parts)
+	}
+	if !canonicalizePrefixes(w, &prefix) {
+		return
+	}
+
+	keys, more := cache.Scan(prefix, string(after), count)
+
+	resp := ScanResponse{Keys: []string{}}
+	for _, key := range keys {
+		if keyAllowed(r, key, AccessRead) {
+			resp.Keys = append(resp.Keys, key)
+		}
+	}
+	if more {
+		resp.Cursor = base64.RawURLEncoding.EncodeToString([]byte(keys[len(keys)-1]))
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleGetAll handles the HTTP GET request to retrieve every entry whose key
+// matches a glob pattern, e.g. user:*:profile, up to limit (default 100)
+func handleGetAll(w http.ResponseWriter, r *http.Request) {
+	type GetAllResponse struct {
+		Values map[string]string `json:"values"`
+		More   bool              `json:"more"` // Matches were left out by the limit
+	}
+
+	query := r.URL.Query()
+	pattern := query.Get("pattern")
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		http.Error(w, "Invalid pattern", http.StatusBadRequest)
+		return
+	}
+	if !canonicalizePrefixes(w, &pattern) {
+		return
+	}
+
+	limit := 100
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxGetAllLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	values, more := cache.GetMatching(pattern, limit)
+	resp := GetAllResponse{Values: make(map[string]string, len(values)), More: more}
+	for key, value := range values {
+		if !keyAllowed(r, key, AccessRead) {
+			continue
+		}
+		decoded, err := transforms.decode(key, value)
+		if err != nil {
+			http.Error(w, "Value transform failed", http.StatusInternalServerError)
+			return
+		}
+		resp.Values[key] = decoded
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleGetSet handles the HTTP POST request to swap a value and return the previous one
+func handleGetSet(w http.ResponseWriter, r *http.Request) {
+	type GetSetRequest struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+		Exp   TTL    `json:"exp"` // Omitted uses the default TTL, negative never expires
+	}
+	type GetSetResponse struct {
+		Previous string `json:"previous"`
+		Found    bool   `json:"found"`
+		Token    uint64 `json:"token"`
+	}
+
+	var req GetSetRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if !canonicalizeKeys(w, &req.Key) {
+		return
+	}
+	if !keyAllowed(r, req.Key, AccessWrite) || !keyAllowed(r, req.Key, AccessRead) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if errs := schemas.validate(req.Key, req.Value); len(errs) > 0 {
+		writeSchemaErrors(w, errs)
+		return
+	}
+
+	stored, err := transforms.encode(req.Key, req.Value)
+	if err != nil {
+		http.Error(w, "Value transform failed", http.StatusInternalServerError)
+		return
+	}
+
+	if !admitWrite(w) {
+		return
+	}
+
+	expiration := time.Duration(req.Exp)
+	prev, found, token, err := cache.GetSet(req.Key, stored, expiration)
+	if errors.Is(err, ErrValueTooLarge) {
+		writePressureHeaders(w)
+		http.Error(w, "Value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if found {
+		if prev, err = transforms.decode(req.Key, prev); err != nil {
+			http.Error(w, "Value transform failed", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(GetSetResponse{Previous: prev, Found: found, Token: token})
+}
+
+// handlePop handles the HTTP POST request to retrieve and remove a value from the cache
+func handlePop(w http.ResponseWriter, r *http.Request) {
+	type PopRequest struct {
+		Key string `json:"key"`
+	}
+
+	var req PopRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !canonicalizeKeys(w, &req.Key) {
+		return
+	}
+	if !keyAllowed(r, req.Key, AccessWrite) || !keyAllowed(r, req.Key, AccessRead) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	value, ok := cache.Pop(req.Key)
+	if !ok {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	value, err = transforms.decode(req.Key, value)
+	if err != nil {
+		http.Error(w, "Value transform failed", http.StatusInternalServerError)
+		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]string{"value": value})
 }
 
+// handleDeleteIfEquals handles the HTTP POST request to delete a key only if
+// it still holds the given value, answering 409 if it holds another
+func handleDeleteIfEquals(w http.ResponseWriter, r *http.Request) {
+	type DeleteIfEqualsRequest struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+
+	var req DeleteIfEqualsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !canonicalizeKeys(w, &req.Key) {
+		return
+	}
+	if !keyAllowed(r, req.Key, AccessWrite) || !keyAllowed(r, req.Key, AccessRead) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Stored values may be encrypted with a random nonce, so compare decoded
+	err = cache.DeleteIf(req.Key, func(stored string) bool {
+		value, err := transforms.decode(req.Key, stored)
+		return err == nil && value == req.Value
+	})
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrValueMismatch):
+		http.Error(w, "Value does not match", http.StatusConflict)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"deleted": true})
+}
+
+// handleExpirePrefix handles the HTTP POST request to set or extend the
+// expiration of every key with a prefix
+func handleExpirePrefix(w http.ResponseWriter, r *http.Request) {
+	type ExpirePrefixRequest struct {
+		Prefix string `json:"prefix"`
+		Exp    TTL    `json:"exp"`    // Negative removes the expiration
+		Extend bool   `json:"extend"` // Add exp to the current expiration instead of resetting it
+	}
+
+	var req ExpirePrefixRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.Prefix == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !canonicalizePrefixes(w, &req.Prefix) {
+		return
+	}
+
+	updated := cache.ExpirePrefix(req.Prefix, time.Duration(req.Exp), req.Extend, func(key string) bool {
+		return keyAllowed(r, key, AccessWrite)
+	})
+	json.NewEncoder(w).Encode(map[string]int{"updated": updated})
+}
+
+// handleMDel handles the HTTP POST request to delete multiple keys and prefixes at once
+func handleMDel(w http.ResponseWriter, r *http.Request) {
+	type MDelRequest struct {
+		Keys     []string `json:"keys"`
+		Prefixes []string `json:"prefixes"`
+	}
+	type MDelResponse struct {
+		Keys     map[string]bool `json:"keys"`
+		Prefixes map[string]int  `json:"prefixes"`
+	}
+
+	var req MDelRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	for i := range req.Keys {
+		if !canonicalizeKeys(w, &req.Keys[i]) {
+			return
+		}
+	}
+	for i := range req.Prefixes {
+		if !canonicalizePrefixes(w, &req.Prefixes[i]) {
+			return
+		}
+	}
+
+	resp := MDelResponse{Keys: make(map[string]bool), Prefixes: make(map[string]int)}
+	for _, key := range req.Keys {
+		resp.Keys[key] = keyAllowed(r, key, AccessWrite) && cache.Delete(key)
+	}
+	for _, prefix := range req.Prefixes {
+		deleted := cache.DeleteMatching(func(key string) bool {
+			return strings.HasPrefix(key, prefix) && keyAllowed(r, key, AccessWrite)
+		})
+		resp.Prefixes[prefix] = len(deleted)
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
 func main() {
-	cache = NewLRUCache(1024)
+	flag.StringVar(&configPath, "config", "", "path to a JSON config file")
+	chaos := flag.Bool("chaos", false, "enable fault injection configured in the chaos config section")
+	selftest := flag.Bool("selftest", false, "run the correctness self-test and micro-benchmark, then exit")
+	simulate := flag.String("simulate", "", "run the operation script in this file on a virtual clock, then exit")
+	replay := flag.String("replay", "", "replay this operation log and report where results diverge, then exit")
+	replayKey := flag.String("replay-key", "", "key of an encrypted operation log: base64, env:VAR or file:/path")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
 
-	r := mux.NewRouter()
-	r.HandleFunc("/set", handleSet).Methods("POST")
-	r.HandleFunc("/get", handleGet).Methods("GET")
+	if *showVersion {
+		fmt.Println(buildInfo())
+		return
+	}
 
-    //cors middleware
-	c := cors.Default().Handler(r)
+	if *selftest {
+		os.Exit(runSelfTest(os.Stdout))
+	}
+	if *replay != "" {
+		f, err := os.Open(*replay)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		var cipher *aesTransform
+		if *replayKey != "" {
+			key, err := resolveKey(*replayKey)
+			if err == nil {
+				cipher, err = newAESTransform(key)
+			}
+			if err != nil {
+				logrus.Fatalf("replay key: %v", err)
+			}
+		}
+		diverged, err := runReplay(f, os.Stdout, cipher)
+		if err != nil {
+			logrus.Fatalf("replay: %v", err)
+		}
+		if diverged > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if *simulate != "" {
+		f, err := os.Open(*simulate)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if err := runSimulation(f, os.Stdout); err != nil {
+			logrus.Fatalf("simulation: %v", err)
+		}
+		return
+	}
 
-	http.ListenAndServe(":8080", c)
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		logrus.Fatalf("loading config: %v", err)
+	}
+
+	upgradePath := os.Getenv(upgradeSocketEnv) // Read before socket activation clears it
+	setKeyRules(cfg.Keys)                      // Prefixes registered below are canonicalized under these
+
+	cache = NewLRUCache(cfg.Capacity)
+	cache.shadows = newShadowSet(cfg.Shadow.Capacities)
+	if err := cache.SetPolicy(cfg.Eviction); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := loadEncryptionKeys(cfg.EncryptionKeys); err != nil {
+		logrus.Fatal(err)
+	}
+	for prefix, names := range cfg.Transforms {
+		if err := transforms.register(prefix, names); err != nil {
+			logrus.Fatalf("transforms for %q: %v", prefix, err)
+		}
+	}
+	setReadOnly(cfg.ReadOnly)
+	if cfg.Contention.Enabled {
+		cache.contention = newContentionDetector(time.Duration(cfg.Contention.ThresholdMs) * time.Millisecond)
+	}
+	for _, pattern := range cfg.Stats.Patterns {
+		if err := cache.patterns.add(pattern); err != nil {
+			logrus.Fatalf("stats pattern %q: %v", pattern, err)
+		}
+	}
+
+	middlewares := []mux.MiddlewareFunc{shedLowPriority, prioritize, timeoutMiddleware}
+	if *chaos {
+		middlewares = append(middlewares, chaosMiddleware(cfg.Chaos))
+	}
+
+	m := &listenerManager{}
+	if cfg.Listeners.HTTP.Enabled {
+		m.Add(newHTTPListener("http", cfg.Listeners.HTTP.Addr, newRouter(allRoutes, middlewares...)))
+	}
+	if cfg.Listeners.Read.Enabled {
+		m.Add(newHTTPListener("http-read", cfg.Listeners.Read.Addr, newRouter([]string{RoutesRead}, middlewares...)))
+	}
+	if cfg.Listeners.Write.Enabled {
+		m.Add(newHTTPListener("http-write", cfg.Listeners.Write.Addr, newRouter([]string{RoutesWrite, RoutesAdmin}, middlewares...)))
+	}
+	if err := applyConfig(cfg); err != nil {
+		logrus.Fatalf("applying config: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, handingOff := context.WithCancel(ctx)
+
+	go watchReloadSignal(ctx)
+	if cfg.OpLog.Path != "" {
+		if err := startOpLog(cache, cfg.OpLog, cfg.Eviction.Policy); err != nil {
+			logrus.Fatalf("op log: %v", err)
+		}
+		go cache.oplog.run(ctx)
+	}
+	history = newStatsHistory(cache, time.Duration(cfg.Stats.HistoryMinutes)*time.Minute)
+	go history.run(ctx)
+	go health.run(ctx, cache)
+	up := &upgrader{m: m, done: handingOff}
+	go up.watch(ctx)
+	if cfg.Memory.TargetBytes > 0 {
+		memController = newMemoryController(cache, cfg.Memory)
+		go memController.run(ctx)
+	}
+	if cfg.StatsD.Addr != "" {
+		e, err := newStatsdEmitter(cache, cfg.StatsD)
+		if err != nil {
+			logrus.Fatalf("statsd: %v", err)
+		}
+		go e.run(ctx)
+	}
+
+	// Take over the cache last, as the old process gives it up on connect
+	if upgradePath != "" {
+		if err := receiveHandoff(upgradePath, cache); err != nil {
+			logrus.Fatalf("receiving cache handoff: %v", err)
+		}
+	}
+
+	if err := m.Run(ctx); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := up.handoff(cache); err != nil {
+		logrus.Fatalf("handing off cache: %v", err)
+	}
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"lrucache/clocktest"
+)
+
+// newFakeCache creates a cache whose expiry is driven by the returned fake clock
+func newFakeCache(capacity int) (*LRUCache, *clocktest.Fake) {
+	clk := clocktest.NewFake(time.Unix(1700000000, 0))
+	return NewLRUCacheWithClock(capacity, clk), clk
+}
+
+func TestExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		advance time.Duration
+		found   bool
+	}{
+		{"before ttl", 10 * time.Second, 9 * time.Second, true},
+		{"at ttl", 10 * time.Second, 10 * time.Second, true},
+		{"just after ttl", 10 * time.Second, 10*time.Second + time.Nanosecond, false},
+		{"after ttl", 10 * time.Second, time.Hour, false},
+		{"no expiration", NoExpiration, 1000 * time.Hour, true},
+		{"default without a default ttl", DefaultExpiration, 1000 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clk := newFakeCache(10)
+			c.Set("k", "v", tt.ttl)
+			clk.Advance(tt.advance)
+
+			if _, ok := c.Get("k"); ok != tt.found {
+				t.Fatalf("found = %t, want %t", ok, tt.found)
+			}
+			if !tt.found {
+				if c.Len() != 0 {
+					t.Errorf("expired item still counted, len %d", c.Len())
+				}
+				if reason := c.MissReason("k"); reason != MissExpired {
+					t.Errorf("miss reason %q, want %q", reason, MissExpired)
+				}
+				if st := c.Stats(); st.Expirations != 1 {
+					t.Errorf("expirations = %d, want 1", st.Expirations)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultExpiration(t *testing.T) {
+	c, clk := newFakeCache(10)
+	c.SetDefaultExpiration(time.Minute)
+	c.Set("default", "v", DefaultExpiration)
+	c.Set("forever", "v", NoExpiration)
+	c.Set("own", "v", 2*time.Minute)
+
+	clk.Advance(time.Minute + time.Second)
+	for key, want := range map[string]bool{"default": false, "forever": true, "own": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%s: found = %t, want %t", key, ok, want)
+		}
+	}
+}
+
+func TestRemainingTTL(t *testing.T) {
+	c, clk := newFakeCache(10)
+	c.Set("k", "v", time.Minute)
+	c.Set("forever", "v", NoExpiration)
+
+	clk.Advance(20 * time.Second)
+	_, info, ok := c.GetWithInfo("k")
+	if !ok {
+		t.Fatal("k missing")
+	}
+	if info.TTL != 40*time.Second {
+		t.Errorf("ttl = %v, want 40s", info.TTL)
+	}
+	if info.Age != 20*time.Second {
+		t.Errorf("age = %v, want 20s", info.Age)
+	}
+	if _, info, _ := c.GetWithInfo("forever"); info.TTL != NoExpiration {
+		t.Errorf("ttl of a key without expiry = %v, want NoExpiration", info.TTL)
+	}
+}
+
+func TestExpiredKeysAreAbsent(t *testing.T) {
+	c, clk := newFakeCache(10)
+	c.Set("a", "1", time.Second)
+	c.Set("b", "2", time.Minute)
+	clk.Advance(2 * time.Second)
+
+	if c.Add("a", "3", time.Minute) != true {
+		t.Error("Add refused a key that had expired")
+	}
+	if c.Add("b", "3", time.Minute) != false {
+		t.Error("Add overwrote a live key")
+	}
+	values := c.MGet([]string{"a", "b"}, 0)
+	if values["a"] != "3" || values["b"] != "2" {
+		t.Errorf("MGet = %v, want a=3 b=2", values)
+	}
+}
+
+func TestExpirePrefix(t *testing.T) {
+	allowAll := func(string) bool { return true }
+
+	t.Run("set", func(t *testing.T) {
+		c, clk := newFakeCache(10)
+		c.Set("p:a", "1", time.Hour)
+		c.Set("p:b", "1", NoExpiration)
+		c.Set("q:a", "1", time.Hour)
+		if n := c.ExpirePrefix("p:", time.Second, false, allowAll); n != 2 {
+			t.Fatalf("updated %d keys, want 2", n)
+		}
+		clk.Advance(2 * time.Second)
+		for key, want := range map[string]bool{"p:a": false, "p:b": false, "q:a": true} {
+			if _, ok := c.Get(key); ok != want {
+				t.Errorf("%s: found = %t, want %t", key, ok, want)
+			}
+		}
+	})
+
+	t.Run("extend", func(t *testing.T) {
+		c, clk := newFakeCache(10)
+		c.Set("p:a", "1", time.Minute)
+		c.Set("p:b", "1", NoExpiration)
+		c.ExpirePrefix("p:", time.Minute, true, allowAll)
+		clk.Advance(90 * time.Second)
+		if _, ok := c.Get("p:a"); !ok {
+			t.Error("extended key expired at its original ttl")
+		}
+		if _, info, _ := c.GetWithInfo("p:b"); info.TTL != NoExpiration {
+			t.Errorf("extending gave a key without expiry a ttl of %v", info.TTL)
+		}
+		clk.Advance(time.Minute)
+		if _, ok := c.Get("p:a"); ok {
+			t.Error("extended key outlived its extension")
+		}
+	})
+
+	t.Run("persist", func(t *testing.T) {
+		c, clk := newFakeCache(10)
+		c.Set("p:a", "1", time.Minute)
+		c.ExpirePrefix("p:", NoExpiration, false, allowAll)
+		clk.Advance(time.Hour)
+		if _, ok := c.Get("p:a"); !ok {
+			t.Error("key expired after its expiry was removed")
+		}
+	})
+}
+
+func TestSetItemsKeepsAbsoluteExpiry(t *testing.T) {
+	c, clk := newFakeCache(10)
+	now := clk.Now()
+	stored, expired := c.SetItems([]CacheItem{
+		{Key: "live", Value: "1", Exp: now.Add(time.Minute)},
+		{Key: "past", Value: "1", Exp: now.Add(-time.Second)},
+		{Key: "forever", Value: "1"},
+	})
+	if stored != 2 || expired != 1 {
+		t.Fatalf("stored %d, expired %d, want 2 and 1", stored, expired)
+	}
+	clk.Advance(time.Minute + time.Second)
+	for key, want := range map[string]bool{"live": false, "past": false, "forever": true} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%s: found = %t, want %t", key, ok, want)
+		}
+	}
+}
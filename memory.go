@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var memController *memoryController // Set when adaptive capacity is enabled
+
+// memoryController adjusts the cache capacity to keep the heap under a budget
+type memoryController struct {
+	cache       *LRUCache
+	target      uint64
+	interval    time.Duration
+	min         int
+	max         int
+	rejectRatio float64
+	pressure    uint64 // float64 bits of the last heap/target sample
+	ceiling     int64  // The configured capacity, which it never grows past; read atomically
+}
+
+// newMemoryController creates a controller from cfg
+func newMemoryController(c *LRUCache, cfg MemoryConfig) *memoryController {
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if cfg.MinCapacity < 1 {
+		cfg.MinCapacity = 1
+	}
+	return &memoryController{
+		cache:       c,
+		target:      cfg.TargetBytes,
+		interval:    interval,
+		min:         cfg.MinCapacity,
+		max:         cfg.MaxCapacity,
+		rejectRatio: cfg.RejectAboveRatio,
+		ceiling:     int64(c.Capacity()),
+	}
+}
+
+// setCeiling makes capacity the most the controller may grow the cache to,
+// shrinking the cache now if it is over it. Config reloads go through here
+// rather than resizing the cache, which would undo the controller's work.
+func (m *memoryController) setCeiling(capacity int) {
+	atomic.StoreInt64(&m.ceiling, int64(capacity))
+	if m.cache.Capacity() > capacity {
+		m.cache.Resize(capacity)
+	}
+}
+
+// run checks memory usage every interval until ctx is done
+func (m *memoryController) run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.adjust()
+		}
+	}
+}
+
+// adjust shrinks the capacity by 10% while the heap is over the target, and
+// grows it by 10% when the heap is well under the target and the cache is
+// full, up to the configured capacity
+func (m *memoryController) adjust() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	atomic.StoreUint64(&m.pressure, math.Float64bits(float64(ms.HeapAlloc)/float64(m.target)))
+
+	capacity := m.cache.Capacity()
+	next := capacity
+	switch {
+	case ms.HeapAlloc > m.target:
+		next = capacity - capacity/10 - 1
+	case ms.HeapAlloc < m.target*8/10 && m.cache.Len() >= capacity:
+		next = capacity + capacity/10 + 1
+	}
+	if next < m.min {
+		next = m.min
+	}
+	if m.max > 0 && next > m.max {
+		next = m.max
+	}
+	if ceiling := int(atomic.LoadInt64(&m.ceiling)); next > ceiling {
+		next = ceiling
+	}
+	if next == capacity {
+		return
+	}
+
+	m.cache.Resize(next)
+	logrus.Infof("memory controller: heap %d bytes (target %d), capacity %d -> %d", ms.HeapAlloc, m.target, capacity, next)
+}
+
+// Pressure returns the heap size relative to the target at the last sample
+func (m *memoryController) Pressure() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&m.pressure))
+}
+
+// writePressureHeaders reports how full the cache and heap are, so writers can back off
+func writePressureHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Cache-Fill", strconv.FormatFloat(float64(cache.Len())/float64(cache.Capacity()), 'f', 3, 64))
+	if memController != nil {
+		w.Header().Set("X-Cache-Memory-Pressure", strconv.FormatFloat(memController.Pressure(), 'f', 3, 64))
+	}
+}
+
+// overRejectRatio reports whether the heap is over the configured reject ratio
+func overRejectRatio() bool {
+	return memController != nil && memController.rejectRatio > 0 && memController.Pressure() >= memController.rejectRatio
+}
+
+// admitWrite rejects a write with 507 while the heap is over the configured
+// reject ratio, since admitting it would only force more eviction churn
+func admitWrite(w http.ResponseWriter) bool {
+	if !overRejectRatio() {
+		return true
+	}
+	writePressureHeaders(w)
+	w.Header().Set("Retry-After", strconv.Itoa(int(memController.interval/time.Second)+1))
+	http.Error(w, "Insufficient storage", http.StatusInsufficientStorage)
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// handlePutObject handles the HTTP PUT request storing the raw request body
+// as the value of the key in the path, with the TTL of the ttl parameter.
+// The body may be chunked; it is read straight into the value, stopping with
+// 413 as soon as it exceeds the maximum value size.
+func handlePutObject(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if !canonicalizeKeys(w, &key) {
+		return
+	}
+	if !keyAllowed(r, key, AccessWrite) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	expiration := DefaultExpiration
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		var err error
+		if expiration, err = parseTTL(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !admitWrite(w) {
+		return
+	}
+
+	value, err := readObject(r)
+	if errors.Is(err, ErrValueTooLarge) {
+		writePressureHeaders(w)
+		http.Error(w, "Value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if errs := schemas.validate(key, value); len(errs) > 0 {
+		writeSchemaErrors(w, errs)
+		return
+	}
+
+	stored, err := transforms.encode(key, value)
+	if err != nil {
+		http.Error(w, "Value transform failed", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := cache.Set(key, stored, expiration)
+	if errors.Is(err, ErrValueTooLarge) {
+		writePressureHeaders(w)
+		http.Error(w, "Value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]uint64{"token": token})
+}
+
+// readObject reads the request body into a single string, without the
+// intermediate copies of io.ReadAll
+func readObject(r *http.Request) (string, error) {
+	limit := int64(cache.MaxValueSize())
+	if limit > 0 && r.ContentLength > limit {
+		cache.recordTooLarge()
+		return "", ErrValueTooLarge
+	}
+
+	var b strings.Builder
+	if r.ContentLength > 0 {
+		b.Grow(int(r.ContentLength))
+	}
+	body := io.Reader(r.Body)
+	if limit > 0 {
+		body = io.LimitReader(r.Body, limit+1)
+	}
+	n, err := io.Copy(&b, body)
+	if err != nil {
+		return "", err
+	}
+	if limit > 0 && n > limit {
+		cache.recordTooLarge()
+		return "", ErrValueTooLarge
+	}
+	return b.String(), nil
+}
+
+// handleGetObject handles the HTTP GET and HEAD requests returning the raw
+// value of the key in the path. Range and conditional requests are served
+// from the stored value without copying it.
+func handleGetObject(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if !canonicalizeKeys(w, &key) {
+		return
+	}
+	if !keyAllowed(r, key, AccessRead) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	value, info, ok := cache.GetWithInfo(key)
+	if !ok {
+		writeMiss(w, cache.MissReason(key))
+		return
+	}
+	value, err := transforms.decode(key, value)
+	if err != nil {
+		http.Error(w, "Value transform failed", http.StatusInternalServerError)
+		return
+	}
+
+	writeItemInfo(w, info)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", info.CreatedAt, strings.NewReader(value))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// opRecorder appends every cache operation to a log in the simulation
+// script format, so -replay can reproduce what the cache did. Operations
+// are recorded with the cache lock held, so the log has the exact order in
+// which they were applied. With a cipher, each record is written as a
+// base64 line of the AES-GCM sealed JSON instead.
+type opRecorder struct {
+	start  time.Time
+	path   string
+	cipher *aesTransform // nil writes plaintext
+
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// startOpLog makes c record its operations to path, appending to the file.
+// The log starts with an init op holding the capacity, the policy and a
+// fresh seed for the cache's randomness. It holds every value written, so
+// the file is made readable by the owner only, and encrypted if cfg names
+// a key. The existing records are checked first and the result reported at
+// /persistence/status.
+func startOpLog(c *LRUCache, cfg OpLogConfig, policy string) error {
+	var cipher *aesTransform
+	if cfg.EncryptionKey != "" {
+		key, ok := encryptionKeys[cfg.EncryptionKey]
+		if !ok {
+			return fmt.Errorf("unknown encryption key %q", cfg.EncryptionKey)
+		}
+		var err error
+		if cipher, err = newAESTransform(key); err != nil {
+			return err
+		}
+	}
+
+	path := cfg.Path
+	check, err := checkOpLog(path, cipher)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := endLine(f); err != nil {
+		f.Close()
+		return err
+	}
+	w := bufio.NewWriter(f)
+	rec := &opRecorder{path: path, cipher: cipher, f: f, w: w, enc: json.NewEncoder(w)}
+
+	seed := time.Now().UnixNano()
+	c.Seed(seed)
+
+	c.lock()
+	defer c.unlock()
+	rec.start = c.clock.Now()
+	rec.write(Op{Op: "init", Capacity: c.capacity, Policy: policy, Seed: seed})
+	c.oplog = rec
+	setOpLogCheck(check)
+	return nil
+}
+
+// endLine terminates a record left without its newline by a crash, so the
+// new run's records start on a line of their own
+func endLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	r, err := os.Open(f.Name())
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := r.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = f.Write([]byte{'\n'})
+	}
+	return err
+}
+
+// write appends op to the log
+func (r *opRecorder) write(op Op) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.encode(op); err != nil {
+		logrus.Errorf("writing op log: %v", err)
+	}
+}
+
+// encode writes op as one line, sealing it if the log is encrypted; the
+// caller must hold r.mu
+func (r *opRecorder) encode(op Op) error {
+	if r.cipher == nil {
+		return r.enc.Encode(op)
+	}
+	raw, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	sealed, err := r.cipher.Encode(string(raw))
+	if err != nil {
+		return err
+	}
+	r.w.WriteString(base64.StdEncoding.EncodeToString([]byte(sealed)))
+	return r.w.WriteByte('\n')
+}
+
+// run flushes the log every second until ctx is done, then closes it
+func (r *opRecorder) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.mu.Lock()
+			r.w.Flush()
+			r.f.Close()
+			r.mu.Unlock()
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			r.w.Flush()
+			r.mu.Unlock()
+		}
+	}
+}
+
+// OpLogPath returns the file operations are recorded to, empty if none
+func (c *LRUCache) OpLogPath() string {
+	c.lock()
+	defer c.unlock()
+	if c.oplog == nil {
+		return ""
+	}
+	return c.oplog.path
+}
+
+// record logs op at the current time if recording is enabled; the caller
+// must hold c.mu. Values of keys matching the redaction rules are replaced
+// with asterisks of the same length, so replays still see their sizes.
+func (c *LRUCache) record(op Op) {
+	if c.oplog == nil {
+		return
+	}
+	if op.Value != "" && redactions.matches(op.Key) {
+		op.Value = strings.Repeat("*", len(op.Value))
+	}
+	op.T = msSince(c.oplog.start, c.clock.Now())
+	c.oplog.write(op)
+}
+
+// runReplay replays an operation log on a virtual clock and compares every
+// recorded get, mget and add result with the replayed one, reporting each
+// divergence to w. It returns the number of divergences. Encrypted records
+// are opened with cipher, which may be nil for a plaintext log.
+//
+// Every init op after the first starts a new run of the server, replayed on
+// a fresh cache; the reported stats are those of the last run. A record that
+// cannot be decoded was torn by a crash if it ends the log or is followed by
+// the init op of the next run, and is dropped and reported. Any other bad
+// record stops the replay.
+func runReplay(r io.Reader, w io.Writer, cipher *aesTransform) (int, error) {
+	sim := newSimulation(io.Discard)
+	br := bufio.NewReader(r)
+	diverged, dropped, n := 0, 0, 0
+	var torn error // Decode error of the previous record, if it failed
+	for n = 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return diverged, fmt.Errorf("op %d: %w", n, err)
+		}
+		var op Op
+		if err := decodeOp(bytes.TrimSpace(line), cipher, &op); err != nil {
+			if torn != nil {
+				return diverged, fmt.Errorf("op %d: %w", n-1, torn)
+			}
+			torn = err
+			continue
+		}
+		if torn != nil {
+			if op.Op != "init" {
+				return diverged, fmt.Errorf("op %d: %w", n-1, torn)
+			}
+			fmt.Fprintf(w, "op %d: dropped record torn by a restart: %v\n", n-1, torn)
+			dropped, torn = dropped+1, nil
+		}
+		if op.Op == "init" && n > 1 {
+			// The server restarted with an empty cache and appended a new run
+			fmt.Fprintf(w, "op %d: new run, replaying it on an empty cache\n", n)
+			sim = newSimulation(io.Discard)
+		}
+
+		found, err := sim.apply(op)
+		if err != nil {
+			return diverged, fmt.Errorf("op %d: %w", n, err)
+		}
+		if op.Found != nil && found != nil && *op.Found != *found {
+			diverged++
+			fmt.Fprintf(w, "op %d t=%.3f %s %s: recorded found=%t, replay found=%t (%s)\n",
+				n, op.T, op.Op, op.Key, *op.Found, *found, sim.cache.MissReason(op.Key))
+		}
+	}
+	if torn != nil {
+		fmt.Fprintf(w, "op %d: dropped truncated final record: %v\n", n-1, torn)
+		dropped++
+	}
+
+	st, _ := json.Marshal(sim.cache.Stats())
+	fmt.Fprintf(w, "replayed %d ops, %d dropped, %d divergences\nstats %s\n", n-1-dropped, dropped, diverged, st)
+	return diverged, nil
+}
+
+// decodeOp decodes one op log line. Plaintext records are JSON objects; any
+// other line is a sealed record, so a log can switch to encryption midway.
+func decodeOp(line []byte, cipher *aesTransform, op *Op) error {
+	if len(line) > 0 && line[0] != '{' {
+		if cipher == nil {
+			return errors.New("record is encrypted, pass -replay-key")
+		}
+		sealed, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil {
+			return err
+		}
+		raw, err := cipher.Decode(string(sealed))
+		if err != nil {
+			return err
+		}
+		line = []byte(raw)
+	}
+	return json.Unmarshal(line, op)
+}
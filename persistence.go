@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OpLogCheck is the result of the consistency check run on the op log when
+// recording starts. Records are classified as -replay treats them: one that
+// does not decode was torn by a crash if it ends the log or is followed by
+// the init op of the next run, and is corrupt otherwise, which includes
+// records sealed with a key other than the configured one.
+type OpLogCheck struct {
+	Path      string    `json:"path"`
+	Encrypted bool      `json:"encrypted"`
+	Records   int       `json:"records"`  // Records that decode
+	Runs      int       `json:"runs"`     // Server runs recorded, counted by their init ops
+	Torn      int       `json:"torn"`     // Dropped by -replay
+	Corrupt   int       `json:"corrupt"`  // Stop -replay
+	Repaired  bool      `json:"repaired"` // Whether a final record was missing its newline and terminated
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+var (
+	persistenceMu sync.Mutex
+	opLogCheck    *OpLogCheck // nil until recording starts
+)
+
+// checkOpLog reads the op log at path, if present, and classifies its records
+func checkOpLog(path string, cipher *aesTransform) (OpLogCheck, error) {
+	check := OpLogCheck{Path: path, Encrypted: cipher != nil, CheckedAt: time.Now().UTC()}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return check, nil
+	}
+	if err != nil {
+		return check, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	torn := false // Whether the previous record failed to decode
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return check, err
+		}
+		check.Repaired = err == io.EOF // The last line had no newline
+
+		var op Op
+		if decodeOp(bytes.TrimSpace(line), cipher, &op) != nil {
+			if torn {
+				check.Corrupt++
+			}
+			torn = true
+			continue
+		}
+		if torn {
+			if op.Op == "init" {
+				check.Torn++
+			} else {
+				check.Corrupt++
+			}
+			torn = false
+		}
+		check.Records++
+		if op.Op == "init" {
+			check.Runs++
+		}
+	}
+	if torn {
+		check.Torn++
+	}
+	return check, nil
+}
+
+// setOpLogCheck records and logs the result of the op log check
+func setOpLogCheck(check OpLogCheck) {
+	persistenceMu.Lock()
+	opLogCheck = &check
+	persistenceMu.Unlock()
+
+	if check.Torn > 0 || check.Corrupt > 0 {
+		logrus.Warnf("op log %s: %d records readable, %d torn (skipped by -replay), %d corrupt",
+			check.Path, check.Records, check.Torn, check.Corrupt)
+		return
+	}
+	logrus.Infof("op log %s: %d records in %d runs checked", check.Path, check.Records, check.Runs)
+}
+
+// handlePersistenceStatus handles the HTTP GET request reporting the state
+// of the files the server persists to, as checked at startup
+func handlePersistenceStatus(w http.ResponseWriter, r *http.Request) {
+	type PersistenceStatus struct {
+		OpLog *OpLogCheck `json:"oplog"` // null when operations are not recorded
+	}
+
+	persistenceMu.Lock()
+	status := PersistenceStatus{OpLog: opLogCheck}
+	persistenceMu.Unlock()
+
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// evictionPolicy decides which item to evict when the cache is over
+// capacity. The cache calls it with c.mu held on every change; the cache's
+// own list always keeps recency order for scans and snapshots regardless.
+type evictionPolicy interface {
+	name() string
+	// added is called when item is inserted, before any eviction it causes
+	added(item *CacheItem)
+	// accessed is called on every hit or update of item
+	accessed(item *CacheItem)
+	// removed is called when item leaves the cache, including by eviction
+	removed(item *CacheItem, reason RemovalReason)
+	// victim returns the key to evict next, avoiding incoming if possible
+	victim(incoming string) string
+	// resize is called when the cache capacity changes
+	resize(capacity int)
+	// stats returns policy-specific statistics, or nil
+	stats() interface{}
+	// needsOrder reports whether hits must move items to the front of the
+	// cache's list. Without it the list keeps insertion order, which is also
+	// what snapshots and policy switches then see.
+	needsOrder() bool
+}
+
+// newPolicy builds the eviction policy named in cfg for c
+func newPolicy(cfg EvictionConfig, c *LRUCache) (evictionPolicy, error) {
+	capacity := c.capacity
+	switch cfg.Policy {
+	case "", "lru":
+		return &lruPolicy{ll: c.ll}, nil
+	case "gdsf":
+		return newGDSFPolicy(), nil
+	case "sampled":
+		return newSampledPolicy(cfg.Samples, c.rand), nil
+	case "arc":
+		return newARCPolicy(capacity), nil
+	case "lirs":
+		return newLIRSPolicy(capacity, cfg.LIRSHIRPercent, cfg.LIRSStackFactor), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", cfg.Policy)
+	}
+}
+
+// SetPolicy switches the eviction policy, replaying the current items into
+// it from least to most recently used, or in insertion order when the
+// previous policy skipped list ordering
+func (c *LRUCache) SetPolicy(cfg EvictionConfig) error {
+	c.lock()
+	defer c.unlock()
+
+	policy, err := newPolicy(cfg, c)
+	if err != nil {
+		return err
+	}
+	for ele := c.ll.Back(); ele != nil; ele = ele.Prev() {
+		policy.added(ele.Value.(*CacheItem))
+	}
+	c.record(Op{Op: "policy", Policy: cfg.Policy})
+	c.policy = policy
+	return nil
+}
+
+// PolicyResponse reports the eviction policy in use
+type PolicyResponse struct {
+	Policy      string      `json:"policy"`
+	PolicyStats interface{} `json:"policy_stats,omitempty"`
+}
+
+// handleGetPolicy handles the HTTP GET request reporting the eviction policy
+func handleGetPolicy(w http.ResponseWriter, r *http.Request) {
+	var resp PolicyResponse
+	resp.Policy, resp.PolicyStats = cache.PolicyStats()
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleSetPolicy handles the HTTP POST request switching the eviction
+// policy at runtime. Entries are kept and replayed into the new policy in
+// recency order; frequency and history tracked by the old policy are lost.
+// The switch lasts until restart, as reloads leave the policy alone.
+func handleSetPolicy(w http.ResponseWriter, r *http.Request) {
+	var req EvictionConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	previous, _ := cache.PolicyStats()
+	if err := cache.SetPolicy(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	appliedMu.Lock()
+	appliedConfig.Eviction = req
+	appliedMu.Unlock()
+
+	var resp PolicyResponse
+	resp.Policy, resp.PolicyStats = cache.PolicyStats()
+	logrus.Infof("eviction policy switched from %s to %s", previous, resp.Policy)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Seed reseeds the cache's randomness, making sampled eviction and XFetch
+// reproducible
+func (c *LRUCache) Seed(seed int64) {
+	c.lock()
+	defer c.unlock()
+	c.rand.Seed(seed)
+}
+
+// PolicyStats returns the name of the eviction policy and its statistics
+func (c *LRUCache) PolicyStats() (string, interface{}) {
+	c.lock()
+	defer c.unlock()
+	return c.policy.name(), c.policy.stats()
+}
+
+// lruPolicy evicts the least recently used item, straight from the cache's list
+type lruPolicy struct {
+	ll *list.List
+}
+
+func (p *lruPolicy) name() string                             { return "lru" }
+func (p *lruPolicy) added(item *CacheItem)                    {}
+func (p *lruPolicy) accessed(item *CacheItem)                 {}
+func (p *lruPolicy) removed(item *CacheItem, _ RemovalReason) {}
+func (p *lruPolicy) resize(capacity int)                      {}
+func (p *lruPolicy) stats() interface{}                       { return nil }
+func (p *lruPolicy) needsOrder() bool                         { return true }
+
+func (p *lruPolicy) victim(incoming string) string {
+	if ele := p.ll.Back(); ele != nil {
+		return ele.Value.(*CacheItem).Key
+	}
+	return ""
+}
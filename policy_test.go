@@ -0,0 +1,236 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+var allPolicies = []string{"lru", "gdsf", "sampled", "arc", "lirs"}
+
+// newPolicyCache creates a fake-clock cache running the named policy
+func newPolicyCache(t *testing.T, capacity int, cfg EvictionConfig) *LRUCache {
+	t.Helper()
+	c, _ := newFakeCache(capacity)
+	c.Seed(1)
+	if err := c.SetPolicy(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// present returns which of keys are in c
+func present(c *LRUCache, keys ...string) map[string]bool {
+	found := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		_, found[key] = c.Get(key)
+	}
+	return found
+}
+
+func TestPolicyCapacity(t *testing.T) {
+	for _, policy := range allPolicies {
+		t.Run(policy, func(t *testing.T) {
+			c := newPolicyCache(t, 100, EvictionConfig{Policy: policy})
+			for i := 0; i < 1000; i++ {
+				c.Set(strconv.Itoa(i), "v", time.Minute)
+				c.Get(strconv.Itoa(i / 2))
+				if c.Len() > 100 {
+					t.Fatalf("%d items in a cache of 100", c.Len())
+				}
+			}
+			if c.Len() != 100 {
+				t.Errorf("%d items after filling a cache of 100", c.Len())
+			}
+			if st := c.Stats(); st.Evictions != 900 {
+				t.Errorf("evictions = %d, want 900", st.Evictions)
+			}
+		})
+	}
+}
+
+// TestPolicyExpiry checks that expired entries leave the policy too, so
+// they neither take room nor get picked as victims afterwards
+func TestPolicyExpiry(t *testing.T) {
+	for _, policy := range allPolicies {
+		t.Run(policy, func(t *testing.T) {
+			c, clk := newFakeCache(3)
+			if err := c.SetPolicy(EvictionConfig{Policy: policy}); err != nil {
+				t.Fatal(err)
+			}
+			c.Set("short", "v", time.Second)
+			c.Set("a", "v", time.Hour)
+			c.Set("b", "v", time.Hour)
+			clk.Advance(2 * time.Second)
+
+			if _, ok := c.Get("short"); ok {
+				t.Fatal("short outlived its ttl")
+			}
+			c.Set("c", "v", time.Hour)
+			for key, ok := range present(c, "a", "b", "c") {
+				if !ok {
+					t.Errorf("%s evicted although the expired entry made room", key)
+				}
+			}
+			if st := c.Stats(); st.Evictions != 0 {
+				t.Errorf("evictions = %d, want 0", st.Evictions)
+			}
+		})
+	}
+}
+
+func TestPolicyKeepsIncoming(t *testing.T) {
+	for _, policy := range allPolicies {
+		t.Run(policy, func(t *testing.T) {
+			c := newPolicyCache(t, 2, EvictionConfig{Policy: policy})
+			for i := 0; i < 50; i++ {
+				key := strconv.Itoa(i)
+				c.Set(key, "v", time.Minute)
+				if _, ok := c.Get(key); !ok {
+					t.Fatalf("%s evicted by its own write", key)
+				}
+			}
+		})
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newPolicyCache(t, 2, EvictionConfig{Policy: "lru"})
+	c.Set("a", "1", time.Minute)
+	c.Set("b", "2", time.Minute)
+	c.Get("a")
+	c.Set("c", "3", time.Minute)
+
+	want := map[string]bool{"a": true, "b": false, "c": true}
+	for key, ok := range present(c, "a", "b", "c") {
+		if ok != want[key] {
+			t.Errorf("%s: present = %t, want %t", key, ok, want[key])
+		}
+	}
+	if !c.WasRecentlyEvicted("b") {
+		t.Error("b not reported as evicted")
+	}
+}
+
+func TestSampledEvictsOldestSampled(t *testing.T) {
+	// With many more samples than entries every entry is compared, which
+	// makes sampled LRU exact
+	c := newPolicyCache(t, 3, EvictionConfig{Policy: "sampled", Samples: 64})
+	c.Set("a", "1", time.Minute)
+	c.Set("b", "2", time.Minute)
+	c.Set("c", "3", time.Minute)
+	c.Get("a")
+	c.Get("c")
+	c.Set("d", "4", time.Minute)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b kept although it was the least recently used")
+	}
+	for key, ok := range present(c, "a", "c", "d") {
+		if !ok {
+			t.Errorf("%s evicted instead of b", key)
+		}
+	}
+}
+
+func TestGDSFKeepsExpensiveValues(t *testing.T) {
+	c := newPolicyCache(t, 2, EvictionConfig{Policy: "gdsf"})
+	c.SetWithCost("dear", "v", time.Minute, 100)
+	c.SetWithCost("cheap", "v", time.Minute, 1)
+	c.SetWithCost("new", "v", time.Minute, 1)
+
+	if _, ok := c.Get("dear"); !ok {
+		t.Error("expensive value evicted")
+	}
+	if _, ok := c.Get("cheap"); ok {
+		t.Error("cheap value kept over the expensive one")
+	}
+}
+
+func TestGDSFKeepsFrequentValues(t *testing.T) {
+	c := newPolicyCache(t, 2, EvictionConfig{Policy: "gdsf"})
+	c.Set("hot", "v", time.Minute)
+	c.Set("cold", "v", time.Minute)
+	for i := 0; i < 10; i++ {
+		c.Get("hot")
+	}
+	c.Set("new", "v", time.Minute)
+
+	if _, ok := c.Get("hot"); !ok {
+		t.Error("frequently read value evicted")
+	}
+	if _, ok := c.Get("cold"); ok {
+		t.Error("unread value kept over the frequently read one")
+	}
+}
+
+// TestScanResistance checks that ARC and LIRS keep a frequently used
+// working set through a scan of one-off keys, which flushes plain LRU
+func TestScanResistance(t *testing.T) {
+	tests := []struct {
+		cfg   EvictionConfig
+		keeps bool
+	}{
+		{EvictionConfig{Policy: "lru"}, false},
+		{EvictionConfig{Policy: "arc"}, true},
+		{EvictionConfig{Policy: "lirs", LIRSHIRPercent: 20}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cfg.Policy, func(t *testing.T) {
+			c := newPolicyCache(t, 10, tt.cfg)
+			hot := []string{"h0", "h1", "h2", "h3"}
+			for round := 0; round < 3; round++ {
+				for _, key := range hot {
+					if _, ok := c.Get(key); !ok {
+						c.Set(key, "v", time.Hour)
+					}
+				}
+			}
+			for i := 0; i < 100; i++ {
+				c.Set("scan"+strconv.Itoa(i), "v", time.Hour)
+			}
+
+			kept := 0
+			for _, ok := range present(c, hot...) {
+				if ok {
+					kept++
+				}
+			}
+			if tt.keeps && kept != len(hot) {
+				t.Errorf("kept %d of %d hot keys through the scan", kept, len(hot))
+			}
+			if !tt.keeps && kept != 0 {
+				t.Errorf("kept %d hot keys, expected the scan to flush them", kept)
+			}
+		})
+	}
+}
+
+func TestSetPolicyKeepsEntries(t *testing.T) {
+	c, clk := newFakeCache(10)
+	for i := 0; i < 10; i++ {
+		c.Set(strconv.Itoa(i), "v", time.Minute)
+	}
+	for _, policy := range append(allPolicies[1:], "lru") {
+		if err := c.SetPolicy(EvictionConfig{Policy: policy}); err != nil {
+			t.Fatal(err)
+		}
+		if name, _ := c.PolicyStats(); name != policy {
+			t.Errorf("policy = %s, want %s", name, policy)
+		}
+		if c.Len() != 10 {
+			t.Fatalf("%s: %d items after the switch, want 10", policy, c.Len())
+		}
+	}
+	// Entries replayed into a new policy keep their expiry
+	clk.Advance(2 * time.Minute)
+	for i := 0; i < 10; i++ {
+		if _, ok := c.Get(strconv.Itoa(i)); ok {
+			t.Errorf("%d outlived its ttl after the policy switches", i)
+		}
+	}
+
+	if err := c.SetPolicy(EvictionConfig{Policy: "mru"}); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request priorities, set with the X-Cache-Priority header
+const (
+	PriorityLow = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+// Defaults for the QoS queues
+const (
+	defaultQueueLength  = 64
+	defaultQueueTimeout = time.Second
+)
+
+var qos = &qosScheduler{} // Orders requests by priority once the concurrency limit is reached
+
+// requestPriority returns the priority named by the X-Cache-Priority header,
+// "high" or "low", defaulting to normal. Anyone may lower their priority,
+// but high is only granted to principals listed in qos.high_priority; for
+// anyone else it counts as normal. It must run after authentication.
+func requestPriority(r *http.Request) int {
+	switch strings.ToLower(r.Header.Get("X-Cache-Priority")) {
+	case "high":
+		p, _ := principalFrom(r)
+		if qos.allowsHigh(p) {
+			return PriorityHigh
+		}
+	case "low":
+		return PriorityLow
+	}
+	return PriorityNormal
+}
+
+// QoSStats reports the state of the priority queues
+type QoSStats struct {
+	Running  int            `json:"running"`
+	Limit    int            `json:"limit"`
+	Queued   map[string]int `json:"queued"`
+	Rejected uint64         `json:"rejected"` // Requests turned away with a full queue or after waiting too long
+}
+
+// qosScheduler runs at most limit requests at once, queueing the rest in one
+// bounded queue per priority and admitting the highest priority first
+type qosScheduler struct {
+	mu       sync.Mutex
+	limit    int // 0 admits everything immediately
+	queueLen int
+	timeout  time.Duration
+	high     []string // ACL entries of the principals allowed high priority
+	running  int
+	queues   [PriorityHigh + 1][]chan struct{}
+	rejected uint64
+}
+
+// configure replaces the limits, admitting waiters if the limit was raised
+func (q *qosScheduler) configure(cfg QoSConfig) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit = cfg.MaxConcurrent
+	q.high = cfg.HighPriority
+	q.queueLen = cfg.QueueLength
+	if q.queueLen <= 0 {
+		q.queueLen = defaultQueueLength
+	}
+	q.timeout = time.Duration(cfg.QueueTimeoutMs) * time.Millisecond
+	if q.timeout <= 0 {
+		q.timeout = defaultQueueTimeout
+	}
+	for (q.limit <= 0 || q.running < q.limit) && q.dispatch() {
+		q.running++
+	}
+}
+
+// allowsHigh reports whether p may send high-priority requests
+func (q *qosScheduler) allowsHigh(p *principal) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return p.matchesAny(q.high)
+}
+
+// dispatch wakes the oldest waiter of the highest priority, reporting whether
+// there was one; the caller must hold q.mu
+func (q *qosScheduler) dispatch() bool {
+	for p := PriorityHigh; p >= PriorityLow; p-- {
+		if len(q.queues[p]) > 0 {
+			close(q.queues[p][0])
+			q.queues[p] = q.queues[p][1:]
+			return true
+		}
+	}
+	return false
+}
+
+// acquire waits for a slot to run a request of priority p, returning false if
+// its queue is full or it waited past the queue timeout
+func (q *qosScheduler) acquire(r *http.Request, p int) bool {
+	q.mu.Lock()
+	if q.limit <= 0 || q.running < q.limit {
+		q.running++
+		q.mu.Unlock()
+		return true
+	}
+	if len(q.queues[p]) >= q.queueLen {
+		q.rejected++
+		q.mu.Unlock()
+		return false
+	}
+	ready := make(chan struct{})
+	q.queues[p] = append(q.queues[p], ready)
+	timer := time.NewTimer(q.timeout)
+	q.mu.Unlock()
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, ch := range q.queues[p] {
+		if ch == ready {
+			q.queues[p] = append(q.queues[p][:i], q.queues[p][i+1:]...)
+			q.rejected++
+			return false
+		}
+	}
+	return true // The slot was handed over while timing out
+}
+
+// release frees a slot, handing it straight to the next waiter if there is one
+func (q *qosScheduler) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if (q.limit > 0 && q.running > q.limit) || !q.dispatch() {
+		q.running--
+	}
+}
+
+// stats returns the current queue state
+func (q *qosScheduler) stats() QoSStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QoSStats{
+		Running: q.running,
+		Limit:   q.limit,
+		Queued: map[string]int{
+			"high":   len(q.queues[PriorityHigh]),
+			"normal": len(q.queues[PriorityNormal]),
+			"low":    len(q.queues[PriorityLow]),
+		},
+		Rejected: q.rejected,
+	}
+}
+
+// prioritize is a middleware queueing requests by X-Cache-Priority once the
+// concurrency limit is reached, answering 503 when a request cannot be queued.
+// Streaming routes bypass it, as they would hold a slot for their lifetime.
+func prioritize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamingRoutes[routeTemplate(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !qos.acquire(r, requestPriority(r)) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Server busy", http.StatusServiceUnavailable)
+			return
+		}
+		defer qos.release()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	receiptKeyMu sync.RWMutex
+	receiptKey   []byte // HMAC key used to sign purge receipts
+)
+
+// PurgeReceipt records what a purge removed and when
+type PurgeReceipt struct {
+	Patterns   []string  `json:"patterns"`
+	Keys       []string  `json:"keys"`
+	PurgedAt   time.Time `json:"purged_at"`
+	RetainedIn []string  `json:"retained_in,omitempty"` // Files still holding the purged values, which purges do not scrub
+	Signature  string    `json:"signature"`
+}
+
+// setReceiptKey sets the receipt signing key, generating an ephemeral one if
+// none is configured
+func setReceiptKey(secret string) {
+	receiptKeyMu.Lock()
+	defer receiptKeyMu.Unlock()
+	if secret != "" {
+		receiptKey = []byte(secret)
+		return
+	}
+	if receiptKey != nil {
+		return
+	}
+	receiptKey = make([]byte, 32)
+	rand.Read(receiptKey)
+	logrus.Warn("no purge receipt secret configured, receipts are signed with an ephemeral key")
+}
+
+// sign computes the receipt signature over its patterns, keys, timestamp and
+// retained files
+func (p *PurgeReceipt) sign() {
+	unsigned := *p
+	unsigned.Signature = ""
+	payload, _ := json.Marshal(unsigned)
+	receiptKeyMu.RLock()
+	mac := hmac.New(sha256.New, receiptKey)
+	receiptKeyMu.RUnlock()
+	mac.Write(payload)
+	p.Signature = hex.EncodeToString(mac.Sum(nil))
+}
+
+// handlePurge handles the HTTP POST request to delete all keys matching a
+// set of glob patterns, canonicalized like key prefixes
+func handlePurge(w http.ResponseWriter, r *http.Request) {
+	type PurgeRequest struct {
+		Patterns []string `json:"patterns"`
+	}
+
+	var req PurgeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || len(req.Patterns) == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	for i := range req.Patterns {
+		if !canonicalizePrefixes(w, &req.Patterns[i]) {
+			return
+		}
+		if _, err := path.Match(req.Patterns[i], ""); err != nil {
+			http.Error(w, "Invalid pattern: "+req.Patterns[i], http.StatusBadRequest)
+			return
+		}
+	}
+
+	keys := cache.DeleteMatching(func(key string) bool {
+		for _, p := range req.Patterns {
+			if ok, _ := path.Match(p, key); ok {
+				return true
+			}
+		}
+		return false
+	})
+
+	receipt := PurgeReceipt{Patterns: req.Patterns, Keys: keys, PurgedAt: time.Now().UTC()}
+	if path := cache.OpLogPath(); path != "" && len(keys) > 0 {
+		receipt.RetainedIn = []string{path}
+	}
+	receipt.sign()
+	logrus.Infof("purged %d keys matching %v", len(keys), req.Patterns)
+
+	json.NewEncoder(w).Encode(receipt)
+}
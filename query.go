@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// queryLoadTimeout bounds a call to a query's loader webhook
+const queryLoadTimeout = 10 * time.Second
+
+// QueryDef is a named look-aside query. Key is a template such as
+// "user:{id}:profile" whose placeholders are filled from the request
+// parameters; on a miss the value is fetched from Loader with the same
+// parameters and cached for TTL.
+type QueryDef struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	Loader string `json:"loader"`
+	TTL    TTL    `json:"ttl"` // Omitted uses the default TTL
+}
+
+// validate checks the definition's name, key template and loader URL
+func (q QueryDef) validate() error {
+	if q.Name == "" {
+		return errors.New("missing name")
+	}
+	if _, err := q.params(); err != nil {
+		return err
+	}
+	u, err := url.Parse(q.Loader)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("loader must be an http or https URL")
+	}
+	return nil
+}
+
+// params returns the placeholder names of the key template
+func (q QueryDef) params() ([]string, error) {
+	var names []string
+	rest := q.Key
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			if strings.IndexByte(rest, '}') >= 0 {
+				return nil, errors.New("unbalanced braces in key template")
+			}
+			return names, nil
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 || strings.IndexByte(rest[:open], '}') >= 0 {
+			return nil, errors.New("unbalanced braces in key template")
+		}
+		name := rest[open+1 : open+end]
+		if name == "" || strings.ContainsAny(name, "{") {
+			return nil, fmt.Errorf("invalid placeholder %q in key template", name)
+		}
+		names = append(names, name)
+		rest = rest[open+end+1:]
+	}
+}
+
+// key fills the template from params. Values have their colons and
+// backslashes escaped as in composite keys, so they cannot spill into
+// neighbouring parts.
+func (q QueryDef) key(params url.Values) (string, error) {
+	var b strings.Builder
+	rest := q.Key
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		end := open + strings.IndexByte(rest[open:], '}')
+		name := rest[open+1 : end]
+		if !params.Has(name) {
+			return "", fmt.Errorf("missing parameter %q", name)
+		}
+		b.WriteString(rest[:open])
+		b.WriteString(strings.TrimSuffix(partsPrefix([]string{params.Get(name)}), ":"))
+		rest = rest[end+1:]
+	}
+}
+
+// queryCall is a loader call in progress, shared by concurrent misses on its key
+type queryCall struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// queryRegistry holds the registered queries and the loader calls in flight
+type queryRegistry struct {
+	mu       sync.RWMutex
+	queries  map[string]QueryDef
+	inflight map[string]*queryCall
+	client   *http.Client
+}
+
+var queries = &queryRegistry{
+	queries:  make(map[string]QueryDef),
+	inflight: make(map[string]*queryCall),
+	client:   &http.Client{Timeout: queryLoadTimeout},
+}
+
+// set adds or replaces the query with its name
+func (q *queryRegistry) set(def QueryDef) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queries[def.Name] = def
+}
+
+// replace swaps in defs as the full set of queries
+func (q *queryRegistry) replace(defs []QueryDef) {
+	byName := make(map[string]QueryDef, len(defs))
+	for _, def := range defs {
+		byName[def.Name] = def
+	}
+	q.mu.Lock()
+	q.queries = byName
+	q.mu.Unlock()
+}
+
+// remove deletes the named query, reporting whether it existed
+func (q *queryRegistry) remove(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.queries[name]
+	delete(q.queries, name)
+	return ok
+}
+
+// get returns the named query
+func (q *queryRegistry) get(name string) (QueryDef, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	def, ok := q.queries[name]
+	return def, ok
+}
+
+// list returns every query ordered by name
+func (q *queryRegistry) list() []QueryDef {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	defs := make([]QueryDef, 0, len(q.queries))
+	for _, def := range q.queries {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// load fetches the value of key from the query's loader, caching it if store
+// is set. Concurrent misses on the same key share one loader call, cached as
+// the first caller decided.
+func (q *queryRegistry) load(ctx context.Context, def QueryDef, key string, params url.Values, store bool) (string, error) {
+	q.mu.Lock()
+	if call, ok := q.inflight[key]; ok {
+		q.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.value, call.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	call := &queryCall{done: make(chan struct{})}
+	q.inflight[key] = call
+	q.mu.Unlock()
+
+	// The call outlives the request that started it, as others may be waiting
+	call.value, call.err = q.fetch(context.WithoutCancel(ctx), def, key, params)
+	if call.err == nil && store {
+		call.err = storeLoaded(key, call.value, time.Duration(def.TTL))
+	}
+
+	q.mu.Lock()
+	delete(q.inflight, key)
+	q.mu.Unlock()
+	close(call.done)
+	return call.value, call.err
+}
+
+// fetch calls the loader webhook with the request parameters
+func (q *queryRegistry) fetch(ctx context.Context, def QueryDef, key string, params url.Values) (string, error) {
+	u, err := url.Parse(def.Loader)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Cache-Key", key)
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("loader returned %d", resp.StatusCode)
+	}
+
+	body := io.Reader(resp.Body)
+	limit := cache.MaxValueSize()
+	if limit > 0 {
+		body = io.LimitReader(resp.Body, int64(limit)+1)
+	}
+	var b strings.Builder
+	if _, err := io.Copy(&b, body); err != nil {
+		return "", err
+	}
+	if limit > 0 && b.Len() > limit {
+		cache.recordTooLarge()
+		return "", ErrValueTooLarge
+	}
+	return b.String(), nil
+}
+
+// storeLoaded validates, transforms and caches a loaded value
+func storeLoaded(key, value string, ttl time.Duration) error {
+	if errs := schemas.validate(key, value); len(errs) > 0 {
+		return fmt.Errorf("loaded value does not match schema: %s", strings.Join(errs, "; "))
+	}
+	stored, err := transforms.encode(key, value)
+	if err != nil {
+		return err
+	}
+	_, err = cache.Set(key, stored, ttl)
+	return err
+}
+
+// handleQuery handles the HTTP GET request serving a registered query: the
+// key is built from the request parameters and served from the cache, or on
+// a miss loaded through the query's webhook and cached if the caller may write
+// the key. X-Cache reports HIT or MISS.
+func handleQuery(w http.ResponseWriter, r *http.Request) {
+	def, ok := queries.get(mux.Vars(r)["name"])
+	if !ok {
+		http.Error(w, "Query not found", http.StatusNotFound)
+		return
+	}
+	params := r.URL.Query()
+	key, err := def.key(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !canonicalizeKeys(w, &key) {
+		return
+	}
+	if !keyAllowed(r, key, AccessRead) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if value, info, ok := cache.GetWithInfo(key); ok {
+		if value, err = transforms.decode(key, value); err != nil {
+			http.Error(w, "Value transform failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Cache", "HIT")
+		writeItemInfo(w, info)
+		json.NewEncoder(w).Encode(map[string]string{"key": key, "value": value})
+		return
+	}
+
+	value, err := queries.load(r.Context(), def, key, params, mayCache(r, key))
+	if err != nil {
+		http.Error(w, "Loader failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("X-Cache", "MISS")
+	json.NewEncoder(w).Encode(map[string]string{"key": key, "value": value})
+}
+
+// mayCache reports whether a value loaded for r may be stored under key. The
+// query route is a read route, so storing is held to what the write routes
+// would allow the caller: not in read-only mode, and with write access to the
+// key through the IP rules, route groups and key ACLs. Otherwise the value is
+// served uncached.
+func mayCache(r *http.Request, key string) bool {
+	if readOnlyState().Enabled || !ipAllowed(r, RoutesWrite) {
+		return false
+	}
+	if p, ok := principalFrom(r); ok && !p.allowsGroup(RoutesWrite) {
+		return false
+	}
+	return keyAllowed(r, key, AccessWrite)
+}
+
+// handleListQueries handles the HTTP GET request listing the registered queries
+func handleListQueries(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(queries.list())
+}
+
+// handleSetQuery handles the HTTP POST request to register or replace a query
+func handleSetQuery(w http.ResponseWriter, r *http.Request) {
+	var def QueryDef
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if err := def.validate(); err != nil {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	queries.set(def)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleRemoveQuery handles the HTTP DELETE request to remove a query by name
+func handleRemoveQuery(w http.ResponseWriter, r *http.Request) {
+	if !queries.remove(r.URL.Query().Get("name")) {
+		http.Error(w, "Query not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RDB opcodes
+const (
+	rdbOpFunction2  = 0xF5
+	rdbOpModuleAux  = 0xF7
+	rdbOpIdle       = 0xF8
+	rdbOpFreq       = 0xF9
+	rdbOpAux        = 0xFA
+	rdbOpResizeDB   = 0xFB
+	rdbOpExpireMs   = 0xFC
+	rdbOpExpireSecs = 0xFD
+	rdbOpSelectDB   = 0xFE
+	rdbOpEOF        = 0xFF
+)
+
+// RDB value types
+const (
+	rdbTypeString          = 0
+	rdbTypeList            = 1
+	rdbTypeSet             = 2
+	rdbTypeZSet            = 3
+	rdbTypeHash            = 4
+	rdbTypeZSet2           = 5
+	rdbTypeZipmap          = 9
+	rdbTypeListZiplist     = 10
+	rdbTypeSetIntset       = 11
+	rdbTypeZSetZiplist     = 12
+	rdbTypeHashZiplist     = 13
+	rdbTypeListQuicklist   = 14
+	rdbTypeStreamListpack  = 15
+	rdbTypeHashListpack    = 16
+	rdbTypeZSetListpack    = 17
+	rdbTypeListQuicklist2  = 18
+	rdbTypeStreamListpack2 = 19
+	rdbTypeSetListpack     = 20
+	rdbTypeStreamListpack3 = 21
+)
+
+// errRDBStringTooLarge is returned for a string over the reader's size limit,
+// which is skipped rather than read into memory
+var errRDBStringTooLarge = errors.New("RDB string too large")
+
+// rdbEntry is a string key read from an RDB file
+type rdbEntry struct {
+	DB       int
+	Key      string
+	Value    string
+	ExpireAt time.Time // Zero if the key has no expiry
+}
+
+// rdbReader parses a Redis RDB dump, yielding its string keys and skipping
+// keys of other types. Lengths come from the file, so strings are only read
+// into memory up to maxString bytes.
+type rdbReader struct {
+	r         *bufio.Reader
+	db        int
+	maxString int
+	done      bool // Whether the EOF opcode was read
+	Skipped   int  // Keys of types other than string
+	Oversized int  // String keys whose key or value exceeded maxString
+}
+
+// newRDBReader checks the RDB magic and version. Strings longer than
+// maxString bytes are skipped.
+func newRDBReader(r io.Reader, maxString int) (*rdbReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, 9)
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+	if string(magic[:5]) != "REDIS" {
+		return nil, errors.New("not an RDB file")
+	}
+	if _, err := strconv.Atoi(string(magic[5:])); err != nil {
+		return nil, fmt.Errorf("invalid RDB version %q", magic[5:])
+	}
+	return &rdbReader{r: br, maxString: maxString}, nil
+}
+
+// next returns the next string key, or io.EOF once the file ends. A file
+// ending before its EOF opcode gives io.ErrUnexpectedEOF.
+func (d *rdbReader) next() (rdbEntry, error) {
+	e, err := d.entry()
+	if err == io.EOF && !d.done {
+		err = io.ErrUnexpectedEOF
+	}
+	return e, err
+}
+
+// entry reads records up to the next string key
+func (d *rdbReader) entry() (rdbEntry, error) {
+	var expireAt time.Time
+	for {
+		op, err := d.r.ReadByte()
+		if err != nil {
+			return rdbEntry{}, err
+		}
+		switch op {
+		case rdbOpEOF:
+			d.done = true
+			return rdbEntry{}, io.EOF
+		case rdbOpSelectDB:
+			db, _, err := d.length()
+			if err != nil {
+				return rdbEntry{}, err
+			}
+			d.db = int(db)
+		case rdbOpResizeDB:
+			if _, _, err := d.length(); err != nil {
+				return rdbEntry{}, err
+			}
+			if _, _, err := d.length(); err != nil {
+				return rdbEntry{}, err
+			}
+		case rdbOpAux:
+			if err := d.skipStrings(2); err != nil {
+				return rdbEntry{}, err
+			}
+		case rdbOpFunction2:
+			if err := d.skipStrings(1); err != nil {
+				return rdbEntry{}, err
+			}
+		case rdbOpFreq:
+			if _, err := d.r.ReadByte(); err != nil {
+				return rdbEntry{}, err
+			}
+		case rdbOpIdle:
+			if _, _, err := d.length(); err != nil {
+				return rdbEntry{}, err
+			}
+		case rdbOpExpireSecs:
+			var secs uint32
+			if err := binary.Read(d.r, binary.LittleEndian, &secs); err != nil {
+				return rdbEntry{}, err
+			}
+			expireAt = time.Unix(int64(secs), 0)
+		case rdbOpExpireMs:
+			var ms uint64
+			if err := binary.Read(d.r, binary.LittleEndian, &ms); err != nil {
+				return rdbEntry{}, err
+			}
+			expireAt = time.UnixMilli(int64(ms))
+		case rdbOpModuleAux:
+			return rdbEntry{}, errors.New("RDB module data is not supported")
+		case rdbTypeStreamListpack, rdbTypeStreamListpack2, rdbTypeStreamListpack3:
+			return rdbEntry{}, fmt.Errorf("unsupported RDB value type %d (stream)", op)
+		default:
+			key, err := d.string()
+			oversized := errors.Is(err, errRDBStringTooLarge)
+			if err != nil && !oversized {
+				return rdbEntry{}, err
+			}
+			if op == rdbTypeString {
+				value, err := d.string()
+				if errors.Is(err, errRDBStringTooLarge) {
+					oversized = true
+				} else if err != nil {
+					return rdbEntry{}, err
+				}
+				if !oversized {
+					return rdbEntry{DB: d.db, Key: key, Value: value, ExpireAt: expireAt}, nil
+				}
+				d.Oversized++
+				expireAt = time.Time{}
+				continue
+			}
+			if err := d.skipValue(op); err != nil {
+				return rdbEntry{}, fmt.Errorf("key %q: %w", key, err)
+			}
+			d.Skipped++
+			expireAt = time.Time{}
+		}
+	}
+}
+
+// skipValue reads past a value of a non-string type
+func (d *rdbReader) skipValue(typ byte) error {
+	switch typ {
+	case rdbTypeZipmap, rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeZSetZiplist,
+		rdbTypeHashZiplist, rdbTypeHashListpack, rdbTypeZSetListpack, rdbTypeSetListpack:
+		return d.skipStrings(1)
+	}
+
+	n, _, err := d.length()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		var err error
+		switch typ {
+		case rdbTypeList, rdbTypeSet, rdbTypeListQuicklist:
+			err = d.skipStrings(1)
+		case rdbTypeHash:
+			err = d.skipStrings(2)
+		case rdbTypeZSet:
+			if err = d.skipStrings(1); err == nil {
+				err = d.skipDouble()
+			}
+		case rdbTypeZSet2:
+			if err = d.skipStrings(1); err == nil {
+				_, err = d.r.Discard(8)
+			}
+		case rdbTypeListQuicklist2:
+			if _, _, err = d.length(); err == nil {
+				err = d.skipStrings(1)
+			}
+		default:
+			return fmt.Errorf("unsupported RDB value type %d", typ)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipDouble reads past a sorted set score in the old string encoding
+func (d *rdbReader) skipDouble() error {
+	n, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if n >= 253 { // NaN, +inf and -inf have no digits
+		return nil
+	}
+	_, err = d.r.Discard(int(n))
+	return err
+}
+
+// skipStrings reads past n strings without holding them in memory
+func (d *rdbReader) skipStrings(n int) error {
+	for i := 0; i < n; i++ {
+		if err := d.skipString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipString reads past one string
+func (d *rdbReader) skipString() error {
+	n, encoded, err := d.length()
+	if err != nil {
+		return err
+	}
+	if !encoded {
+		return d.discard(n)
+	}
+	switch n {
+	case 0:
+		return d.discard(1)
+	case 1:
+		return d.discard(2)
+	case 2:
+		return d.discard(4)
+	case 3:
+		clen, _, err := d.length()
+		if err != nil {
+			return err
+		}
+		if _, _, err := d.length(); err != nil {
+			return err
+		}
+		return d.discard(clen)
+	}
+	return fmt.Errorf("invalid RDB string encoding %d", n)
+}
+
+// discard reads past n bytes
+func (d *rdbReader) discard(n uint64) error {
+	if n > math.MaxInt64 {
+		return fmt.Errorf("invalid RDB length %d", n)
+	}
+	_, err := io.CopyN(io.Discard, d.r, int64(n))
+	return err
+}
+
+// length reads a length-encoded integer. If encoded is true, the value is
+// instead the format of a specially encoded string.
+func (d *rdbReader) length() (n uint64, encoded bool, err error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := d.r.ReadByte()
+		return uint64(b&0x3F)<<8 | uint64(next), false, err
+	case 2:
+		switch b {
+		case 0x80:
+			var v uint32
+			err := binary.Read(d.r, binary.BigEndian, &v)
+			return uint64(v), false, err
+		case 0x81:
+			var v uint64
+			err := binary.Read(d.r, binary.BigEndian, &v)
+			return v, false, err
+		}
+		return 0, false, fmt.Errorf("invalid RDB length prefix 0x%x", b)
+	}
+	return uint64(b & 0x3F), true, nil
+}
+
+// string reads a string, which may be stored as an integer or LZF compressed
+func (d *rdbReader) string() (string, error) {
+	n, encoded, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		if n > uint64(d.maxString) {
+			if err := d.discard(n); err != nil {
+				return "", err
+			}
+			return "", errRDBStringTooLarge
+		}
+		// Grow with the data read rather than trusting n up front
+		var b strings.Builder
+		if _, err := io.CopyN(&b, d.r, int64(n)); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+
+	switch n {
+	case 0:
+		var v int8
+		err := binary.Read(d.r, binary.LittleEndian, &v)
+		return strconv.Itoa(int(v)), err
+	case 1:
+		var v int16
+		err := binary.Read(d.r, binary.LittleEndian, &v)
+		return strconv.Itoa(int(v)), err
+	case 2:
+		var v int32
+		err := binary.Read(d.r, binary.LittleEndian, &v)
+		return strconv.Itoa(int(v)), err
+	case 3:
+		clen, _, err := d.length()
+		if err != nil {
+			return "", err
+		}
+		ulen, _, err := d.length()
+		if err != nil {
+			return "", err
+		}
+		if clen > uint64(d.maxString) || ulen > uint64(d.maxString) {
+			if err := d.discard(clen); err != nil {
+				return "", err
+			}
+			return "", errRDBStringTooLarge
+		}
+		var compressed bytes.Buffer
+		if _, err := io.CopyN(&compressed, d.r, int64(clen)); err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(compressed.Bytes(), int(ulen))
+		return string(out), err
+	}
+	return "", fmt.Errorf("invalid RDB string encoding %d", n)
+}
+
+// lzfDecompress expands LZF-compressed data to its known length
+func lzfDecompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 { // Literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errors.New("truncated LZF literal")
+			}
+			if len(out)+n > size {
+				return nil, errors.New("LZF data overruns its length")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		// Back reference of n bytes
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errors.New("truncated LZF reference")
+			}
+			n += int(in[i])
+			i++
+		}
+		n += 2
+		if i >= len(in) {
+			return nil, errors.New("truncated LZF reference")
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errors.New("invalid LZF reference")
+		}
+		if len(out)+n > size {
+			return nil, errors.New("LZF data overruns its length")
+		}
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != size {
+		return nil, fmt.Errorf("LZF data expanded to %d bytes, expected %d", len(out), size)
+	}
+	return out, nil
+}
+
+// handleImportRedis handles the HTTP POST request loading the string keys of
+// a Redis RDB dump sent as the body, with their remaining TTLs. Only keys
+// of database 0 are loaded unless another is chosen with ?db=N. The dump is
+// parsed as it streams in.
+func handleImportRedis(w http.ResponseWriter, r *http.Request) {
+	type ImportResponse struct {
+		Imported int    `json:"imported"`
+		Expired  int    `json:"expired"`  // Keys already past their expiry
+		Skipped  int    `json:"skipped"`  // Keys of other types or databases
+		Rejected int    `json:"rejected"` // Invalid or oversized keys, or keys refused by ACLs, schemas or size limits
+		Error    string `json:"error,omitempty"`
+	}
+
+	db := 0
+	if v := r.URL.Query().Get("db"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid db", http.StatusBadRequest)
+			return
+		}
+		db = n
+	}
+	if !admitWrite(w) {
+		return
+	}
+
+	maxString := cache.MaxValueSize()
+	if maxString <= 0 {
+		maxString = maxBufferedBody
+	}
+	rdb, err := newRDBReader(r.Body, maxString)
+	if err != nil {
+		http.Error(w, "Invalid RDB file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resp ImportResponse
+	for {
+		if err := r.Context().Err(); err != nil {
+			resp.Error = err.Error()
+			w.WriteHeader(http.StatusGatewayTimeout)
+			break
+		}
+		e, err := rdb.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			resp.Error = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			break
+		}
+
+		if e.DB != db {
+			resp.Skipped++
+			continue
+		}
+		ttl := NoExpiration
+		if !e.ExpireAt.IsZero() {
+			if ttl = time.Until(e.ExpireAt); ttl <= 0 {
+				resp.Expired++
+				continue
+			}
+		}
+		if e.Key, err = canonicalKey(e.Key); err != nil {
+			resp.Rejected++
+			continue
+		}
+		if !keyAllowed(r, e.Key, AccessWrite) || len(schemas.validate(e.Key, e.Value)) > 0 {
+			resp.Rejected++
+			continue
+		}
+		stored, err := transforms.encode(e.Key, e.Value)
+		if err != nil {
+			resp.Rejected++
+			continue
+		}
+		if _, err := cache.Set(e.Key, stored, ttl); err != nil {
+			resp.Rejected++
+			continue
+		}
+		resp.Imported++
+	}
+	resp.Skipped += rdb.Skipped
+	resp.Rejected += rdb.Oversized
+
+	json.NewEncoder(w).Encode(resp)
+}
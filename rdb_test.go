@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rdbBuilder writes an RDB dump for the reader to parse
+type rdbBuilder struct {
+	bytes.Buffer
+}
+
+func newRDBBuilder() *rdbBuilder {
+	b := &rdbBuilder{}
+	b.WriteString("REDIS0011")
+	return b
+}
+
+func (b *rdbBuilder) length(n int) *rdbBuilder {
+	switch {
+	case n < 1<<6:
+		b.WriteByte(byte(n))
+	case n < 1<<14:
+		b.WriteByte(0x40 | byte(n>>8))
+		b.WriteByte(byte(n))
+	default:
+		b.WriteByte(0x80)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	}
+	return b
+}
+
+func (b *rdbBuilder) str(s string) *rdbBuilder {
+	b.length(len(s))
+	b.WriteString(s)
+	return b
+}
+
+func (b *rdbBuilder) op(op byte) *rdbBuilder {
+	b.WriteByte(op)
+	return b
+}
+
+func (b *rdbBuilder) set(key, value string) *rdbBuilder {
+	return b.op(rdbTypeString).str(key).str(value)
+}
+
+// readRDB reads all entries of a dump, returning the reader for its counters
+func readRDB(t *testing.T, dump []byte, maxString int) ([]rdbEntry, *rdbReader, error) {
+	t.Helper()
+	d, err := newRDBReader(bytes.NewReader(dump), maxString)
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries []rdbEntry
+	for {
+		e, err := d.next()
+		if err == io.EOF {
+			return entries, d, nil
+		}
+		if err != nil {
+			return entries, d, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+func TestRDBReader(t *testing.T) {
+	expireAt := time.Unix(1700000000, 0)
+	b := newRDBBuilder()
+	b.op(rdbOpAux).str("redis-ver").str("7.2.0")
+	b.op(rdbOpSelectDB).length(0)
+	b.op(rdbOpResizeDB).length(3).length(1)
+	b.set("plain", "v1")
+	b.op(rdbOpExpireMs)
+	binary.Write(b, binary.LittleEndian, uint64(expireAt.UnixMilli()))
+	b.set("ms", "v2")
+	b.op(rdbOpExpireSecs)
+	binary.Write(b, binary.LittleEndian, uint32(expireAt.Unix()))
+	b.op(rdbOpFreq).op(5)
+	b.set("secs", "v3")
+	// An integer encoded value
+	b.op(rdbTypeString).str("int").op(0xC1)
+	binary.Write(b, binary.LittleEndian, int16(-1234))
+	// Other types are skipped along with their expiry
+	b.op(rdbOpExpireSecs)
+	binary.Write(b, binary.LittleEndian, uint32(expireAt.Unix()))
+	b.op(rdbTypeList).str("list").length(2).str("a").str("b")
+	b.op(rdbTypeHash).str("hash").length(1).str("f").str("v")
+	b.op(rdbTypeSetListpack).str("set").str("listpack bytes")
+	b.op(rdbOpSelectDB).length(2)
+	b.set("other", "v4")
+	b.op(rdbOpEOF)
+
+	entries, d, err := readRDB(t, b.Bytes(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rdbEntry{
+		{Key: "plain", Value: "v1"},
+		{Key: "ms", Value: "v2", ExpireAt: expireAt},
+		{Key: "secs", Value: "v3", ExpireAt: expireAt},
+		{Key: "int", Value: "-1234"},
+		{DB: 2, Key: "other", Value: "v4"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("read %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, e := range entries {
+		if e.DB != want[i].DB || e.Key != want[i].Key || e.Value != want[i].Value || !e.ExpireAt.Equal(want[i].ExpireAt) {
+			t.Errorf("entry %d = %+v, want %+v", i, e, want[i])
+		}
+	}
+	if d.Skipped != 3 {
+		t.Errorf("skipped = %d, want 3", d.Skipped)
+	}
+}
+
+func TestRDBReaderLZF(t *testing.T) {
+	// "aaaaaaaaaa": a literal "a" then a back reference of 9 bytes at distance 1
+	compressed := []byte{0x00, 'a', 0xE0, 0x00, 0x00}
+	b := newRDBBuilder()
+	b.op(rdbTypeString).str("k").op(0xC3).length(len(compressed)).length(10)
+	b.Write(compressed)
+	b.op(rdbOpEOF)
+
+	entries, _, err := readRDB(t, b.Bytes(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Value != strings.Repeat("a", 10) {
+		t.Errorf("entries = %+v, want k=aaaaaaaaaa", entries)
+	}
+}
+
+func TestRDBReaderOversized(t *testing.T) {
+	b := newRDBBuilder()
+	b.set("big", strings.Repeat("x", 100))
+	b.set(strings.Repeat("k", 100), "v")
+	b.set("small", "v")
+	b.op(rdbOpEOF)
+
+	entries, d, err := readRDB(t, b.Bytes(), 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Key != "small" {
+		t.Errorf("entries = %+v, want only small", entries)
+	}
+	if d.Oversized != 2 {
+		t.Errorf("oversized = %d, want 2", d.Oversized)
+	}
+}
+
+func TestRDBReaderMalformed(t *testing.T) {
+	valid := newRDBBuilder().set("k", "value").op(rdbOpEOF).Bytes()
+
+	tests := []struct {
+		name string
+		dump []byte
+		want error // nil to only require some error
+	}{
+		{"bad magic", []byte("RODIS0011"), nil},
+		{"bad version", []byte("REDIS00x1"), nil},
+		{"short header", []byte("REDIS"), io.ErrUnexpectedEOF},
+		{"no EOF opcode", valid[:len(valid)-1], io.ErrUnexpectedEOF},
+		{"truncated value", valid[:len(valid)-3], io.ErrUnexpectedEOF},
+		{"truncated expiry", newRDBBuilder().op(rdbOpExpireMs).op(1).Bytes(), io.ErrUnexpectedEOF},
+		// A declared length of 4GB must fail on the missing data, not allocate it
+		{"huge length", append(newRDBBuilder().op(rdbTypeString).str("k").op(0x80).Bytes(), 0xFF, 0xFF, 0xFF, 0xFF, 'v'), io.ErrUnexpectedEOF},
+		{"huge skipped length", append(newRDBBuilder().op(rdbTypeList).str("k").op(0x81).Bytes(), 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF), nil},
+		{"invalid length prefix", newRDBBuilder().op(rdbTypeString).op(0x82).Bytes(), nil},
+		{"invalid string encoding", newRDBBuilder().op(rdbTypeString).op(0xC4).Bytes(), nil},
+		{"stream", newRDBBuilder().op(rdbTypeStreamListpack2).str("s").Bytes(), nil},
+		{"module data", newRDBBuilder().op(rdbOpModuleAux).Bytes(), nil},
+		{"unknown type", newRDBBuilder().op(7).str("k").length(1).Bytes(), nil},
+		{"LZF overrun", newRDBBuilder().op(rdbTypeString).str("k").op(0xC3).length(5).length(2).op(0x00).op('a').op(0xE0).op(0x00).op(0x00).Bytes(), nil},
+		{"LZF bad reference", newRDBBuilder().op(rdbTypeString).str("k").op(0xC3).length(3).length(3).op(0x20).op(0x05).op(0x00).Bytes(), nil},
+		{"LZF short", newRDBBuilder().op(rdbTypeString).str("k").op(0xC3).length(2).length(5).op(0x00).op('a').Bytes(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := readRDB(t, tt.dump, 1024)
+			if err == nil {
+				t.Fatal("malformed dump accepted")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLZFDecompress(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		size int
+		want string
+		ok   bool
+	}{
+		{"literal", []byte{0x02, 'a', 'b', 'c'}, 3, "abc", true},
+		{"reference", []byte{0x01, 'a', 'b', 0x20, 0x01}, 5, "ababa", true},
+		{"long reference", []byte{0x00, 'a', 0xE0, 0x01, 0x00}, 11, "aaaaaaaaaaa", true},
+		{"truncated literal", []byte{0x05, 'a'}, 6, "", false},
+		{"truncated reference", []byte{0x00, 'a', 0x20}, 4, "", false},
+		{"reference before start", []byte{0x00, 'a', 0x20, 0x01}, 4, "", false},
+		{"longer than size", []byte{0x02, 'a', 'b', 'c'}, 2, "", false},
+		{"shorter than size", []byte{0x02, 'a', 'b', 'c'}, 4, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := lzfDecompress(tt.in, tt.size)
+			if (err == nil) != tt.ok {
+				t.Fatalf("error = %v, want ok %t", err, tt.ok)
+			}
+			if tt.ok && string(out) != tt.want {
+				t.Errorf("out = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
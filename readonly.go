@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// defaultRetryAfter is the Retry-After sent in read-only mode when none is set
+const defaultRetryAfter = 30
+
+var (
+	readOnlyMu sync.RWMutex
+	readOnly   ReadOnlyConfig
+)
+
+// setReadOnly switches read-only mode
+func setReadOnly(cfg ReadOnlyConfig) {
+	if cfg.RetryAfterSeconds <= 0 {
+		cfg.RetryAfterSeconds = defaultRetryAfter
+	}
+	readOnlyMu.Lock()
+	readOnly = cfg
+	readOnlyMu.Unlock()
+}
+
+// readOnlyState returns the current read-only settings
+func readOnlyState() ReadOnlyConfig {
+	readOnlyMu.RLock()
+	defer readOnlyMu.RUnlock()
+	return readOnly
+}
+
+// readOnlyGuard is a middleware answering 503 with Retry-After while the
+// server is in read-only mode
+func readOnlyGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state := readOnlyState(); state.Enabled {
+			w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfterSeconds))
+			http.Error(w, "Server is read-only", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleGetReadOnly handles the HTTP GET request reporting read-only mode
+func handleGetReadOnly(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(readOnlyState())
+}
+
+// handleSetReadOnly handles the HTTP POST request to turn read-only mode on or off
+func handleSetReadOnly(w http.ResponseWriter, r *http.Request) {
+	var req ReadOnlyConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	setReadOnly(req)
+	json.NewEncoder(w).Encode(readOnlyState())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var redactions redactionRules // Keys whose values are masked in exports and the op log
+
+// redactionRules matches the keys whose values must not leave the server
+// other than in answer to a client allowed to read them
+type redactionRules struct {
+	mu       sync.RWMutex
+	prefixes []string
+	patterns []*regexp.Regexp
+}
+
+// compileRedaction compiles the patterns of cfg and canonicalizes its
+// prefixes under keys, like the keys they must match
+func compileRedaction(cfg RedactionConfig, keys KeysConfig) (prefixes []string, patterns []*regexp.Regexp, err error) {
+	for _, p := range cfg.KeyPrefixes {
+		prefix, err := keys.prefix(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("redaction prefix %q: %v", p, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	for _, p := range cfg.KeyPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("redaction pattern %q: %v", p, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return prefixes, patterns, nil
+}
+
+// set replaces the redaction rules with compiled ones
+func (r *redactionRules) set(prefixes []string, patterns []*regexp.Regexp) {
+	r.mu.Lock()
+	r.prefixes = prefixes
+	r.patterns = patterns
+	r.mu.Unlock()
+}
+
+// matches reports whether the value of key must be redacted
+func (r *redactionRules) matches(key string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
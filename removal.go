@@ -0,0 +1,54 @@
+package main
+
+// RemovalReason says why an entry left the cache
+type RemovalReason int
+
+const (
+	RemovalExpired     RemovalReason = iota + 1 // Its TTL ran out
+	RemovalEvicted                              // Pushed out for capacity
+	RemovalReplaced                             // Overwritten by another write to the key
+	RemovalDeleted                              // Removed explicitly
+	RemovalInvalidated                          // Removed because an entry it depends on changed
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case RemovalExpired:
+		return "expired"
+	case RemovalEvicted:
+		return "evicted"
+	case RemovalReplaced:
+		return "replaced"
+	case RemovalDeleted:
+		return "deleted"
+	case RemovalInvalidated:
+		return "invalidated"
+	default:
+		return "unknown"
+	}
+}
+
+// eventType returns the event published for a removal with this reason
+func (r RemovalReason) eventType() string {
+	switch r {
+	case RemovalExpired:
+		return EventExpire
+	case RemovalEvicted:
+		return EventEvict
+	case RemovalReplaced:
+		return EventSet
+	default:
+		return EventDelete
+	}
+}
+
+// queueRemoval schedules item's removal callback, if any, to run with reason
+// once c.mu is released; the caller must hold c.mu
+func (c *LRUCache) queueRemoval(item *CacheItem, reason RemovalReason) {
+	if item.onRemove == nil {
+		return
+	}
+	fn := item.onRemove
+	item.onRemove = nil
+	c.pending = append(c.pending, func() { fn(reason) })
+}
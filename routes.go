@@ -0,0 +1,106 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Route groups, so each listener can expose a subset of the API
+const (
+	RoutesRead  = "read"
+	RoutesWrite = "write"
+	RoutesAdmin = "admin"
+)
+
+var allRoutes = []string{RoutesRead, RoutesWrite, RoutesAdmin}
+
+// registerReadRoutes adds the endpoints that read the cache. The only one
+// that can write is /query/{name}, which caches a loaded miss only when the
+// caller could have written the key through the write routes.
+func registerReadRoutes(r *mux.Router) {
+	r.HandleFunc("/get", handleGet).Methods("GET")
+	r.HandleFunc("/mget", handleMGet).Methods("GET")
+	r.HandleFunc("/scan", handleScan).Methods("GET")
+	r.HandleFunc("/getall", handleGetAll).Methods("GET")
+	r.HandleFunc("/objects/{key:.+}", handleGetObject).Methods("GET", "HEAD")
+	r.HandleFunc("/query/{name}", handleQuery).Methods("GET")
+	r.HandleFunc("/events", handleEvents).Methods("GET")
+	r.HandleFunc("/shadow", handleShadow).Methods("GET")
+	r.HandleFunc("/stats", handleStats).Methods("GET")
+	r.HandleFunc("/stats/history", handleStatsHistory).Methods("GET")
+	r.HandleFunc("/stats/large", handleLargeItems).Methods("GET")
+	r.HandleFunc("/health", handleHealth).Methods("GET")
+	r.HandleFunc("/version", handleVersion).Methods("GET")
+	r.HandleFunc("/slowlog", handleSlowLog).Methods("GET")
+	r.HandleFunc("/metrics", handleMetrics).Methods("GET")
+	r.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+}
+
+// registerWriteRoutes adds the endpoints that modify cache entries
+func registerWriteRoutes(r *mux.Router) {
+	r.HandleFunc("/set", handleSet).Methods("POST")
+	r.HandleFunc("/objects/{key:.+}", handlePutObject).Methods("PUT")
+	r.HandleFunc("/getset", handleGetSet).Methods("POST")
+	r.HandleFunc("/pop", handlePop).Methods("POST")
+	r.HandleFunc("/append", handleAppend).Methods("POST")
+	r.HandleFunc("/prepend", handlePrepend).Methods("POST")
+	r.HandleFunc("/mdel", handleMDel).Methods("POST")
+	r.HandleFunc("/delete-if-equals", handleDeleteIfEquals).Methods("POST")
+	r.HandleFunc("/txn", handleTxn).Methods("POST")
+	r.HandleFunc("/expire-prefix", handleExpirePrefix).Methods("POST")
+}
+
+// registerAdminRoutes adds the endpoints that manage the server itself
+func registerAdminRoutes(r *mux.Router) {
+	r.HandleFunc("/admin/reload", handleReload).Methods("POST")
+	r.Handle("/admin/purge", readOnlyGuard(http.HandlerFunc(handlePurge))).Methods("POST")
+	r.HandleFunc("/slowlog", handleResetSlowLog).Methods("DELETE")
+	r.HandleFunc("/info", handleInfo).Methods("GET")
+	r.HandleFunc("/persistence/status", handlePersistenceStatus).Methods("GET")
+	r.HandleFunc("/admin/readonly", handleGetReadOnly).Methods("GET")
+	r.HandleFunc("/admin/readonly", handleSetReadOnly).Methods("POST")
+	r.HandleFunc("/admin/policy", handleGetPolicy).Methods("GET")
+	r.HandleFunc("/admin/policy", handleSetPolicy).Methods("POST")
+	r.HandleFunc("/export", handleExport).Methods("GET")
+	r.Handle("/import", readOnlyGuard(http.HandlerFunc(handleImport))).Methods("POST")
+	r.Handle("/import/redis", readOnlyGuard(http.HandlerFunc(handleImportRedis))).Methods("POST")
+	r.HandleFunc("/admin/patterns", handleAddPattern).Methods("POST")
+	r.HandleFunc("/admin/patterns", handleRemovePattern).Methods("DELETE")
+	r.HandleFunc("/admin/schemas", handleAddSchema).Methods("POST")
+	r.HandleFunc("/admin/schemas", handleRemoveSchema).Methods("DELETE")
+	r.HandleFunc("/admin/acl", handleListACL).Methods("GET")
+	r.HandleFunc("/admin/acl", handleSetACL).Methods("POST")
+	r.HandleFunc("/admin/acl", handleRemoveACL).Methods("DELETE")
+	r.HandleFunc("/admin/queries", handleListQueries).Methods("GET")
+	r.HandleFunc("/admin/queries", handleSetQuery).Methods("POST")
+	r.HandleFunc("/admin/queries", handleRemoveQuery).Methods("DELETE")
+}
+
+// newRouter builds a router serving the given route groups behind a
+// reloadable CORS handler. The in-flight limit runs first so excess requests
+// are turned away before any other work; the middlewares run after
+// authentication, as request priorities depend on the principal.
+func newRouter(groups []string, middlewares ...mux.MiddlewareFunc) *reloadableCORS {
+	r := mux.NewRouter()
+	r.Use(limitInFlight)
+	for _, group := range groups {
+		sub := r.NewRoute().Subrouter()
+		sub.Use(ipFilter(group), authenticate(group))
+		sub.Use(middlewares...)
+		switch group {
+		case RoutesRead:
+			registerReadRoutes(sub)
+		case RoutesWrite:
+			sub.Use(readOnlyGuard, idempotent)
+			registerWriteRoutes(sub)
+		case RoutesAdmin:
+			registerAdminRoutes(sub)
+		}
+	}
+
+	c := newReloadableCORS(r)
+	corsHandlers = append(corsHandlers, c)
+	return c
+}
@@ -0,0 +1,98 @@
+package main
+
+import "math/rand"
+
+// defaultEvictionSamples is how many entries sampled LRU compares by default
+const defaultEvictionSamples = 5
+
+// sampledPolicy approximates LRU the way Redis does: it samples a few random
+// entries and evicts the least recently used of them. It keeps only a
+// last-access stamp per entry and the cache skips reordering its list on
+// hits, so a hit costs a map lookup and a counter bump, at a small cost in
+// hit rate. Snapshots taken under it list items in insertion order.
+type sampledPolicy struct {
+	samples int
+	rand    *rand.Rand
+	clock   uint64 // Logical access clock
+
+	keys    []string       // All keys, for uniform sampling
+	entries map[string]int // Key to index in keys
+	stamps  []uint64       // Last access per index in keys
+}
+
+// SampledStats reports the sampled LRU settings
+type SampledStats struct {
+	Samples int `json:"samples"`
+}
+
+func newSampledPolicy(samples int, rng *rand.Rand) *sampledPolicy {
+	if samples < 1 {
+		samples = defaultEvictionSamples
+	}
+	return &sampledPolicy{samples: samples, rand: rng, entries: make(map[string]int)}
+}
+
+func (p *sampledPolicy) name() string { return "sampled" }
+
+func (p *sampledPolicy) added(item *CacheItem) {
+	p.clock++
+	p.entries[item.Key] = len(p.keys)
+	p.keys = append(p.keys, item.Key)
+	p.stamps = append(p.stamps, p.clock)
+}
+
+func (p *sampledPolicy) accessed(item *CacheItem) {
+	if i, ok := p.entries[item.Key]; ok {
+		p.clock++
+		p.stamps[i] = p.clock
+	}
+}
+
+func (p *sampledPolicy) removed(item *CacheItem, _ RemovalReason) {
+	i, ok := p.entries[item.Key]
+	if !ok {
+		return
+	}
+	// swap the last key into the hole
+	last := len(p.keys) - 1
+	p.keys[i], p.stamps[i] = p.keys[last], p.stamps[last]
+	p.entries[p.keys[i]] = i
+	p.keys, p.stamps = p.keys[:last], p.stamps[:last]
+	delete(p.entries, item.Key)
+}
+
+func (p *sampledPolicy) victim(incoming string) string {
+	if len(p.keys) == 0 {
+		return ""
+	}
+	best := -1
+	for n := 0; n < p.samples; n++ {
+		i := p.rand.Intn(len(p.keys))
+		if p.keys[i] == incoming && len(p.keys) > 1 {
+			continue
+		}
+		if best < 0 || p.stamps[i] < p.stamps[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		// every sample hit the incoming key; fall back to a linear pick
+		for i, key := range p.keys {
+			if key != incoming {
+				return key
+			}
+			best = i
+		}
+	}
+	return p.keys[best]
+}
+
+func (p *sampledPolicy) resize(capacity int) {}
+
+func (p *sampledPolicy) stats() interface{} {
+	return SampledStats{Samples: p.samples}
+}
+
+// needsOrder is false: the stamps replace the cache's recency list, which
+// is what makes hits cheap
+func (p *sampledPolicy) needsOrder() bool { return false }
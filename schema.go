@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Schema is the subset of JSON Schema supported for value validation:
+// type, properties, required, additionalProperties, items, enum,
+// minimum/maximum and minLength/maxLength. Other keywords are rejected
+// rather than silently not enforced.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+}
+
+// UnmarshalJSON decodes a schema, failing on unsupported keywords and types
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	type plain Schema // Without this method, so nested schemas still use it
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var p plain
+	if err := dec.Decode(&p); err != nil {
+		return err
+	}
+	switch p.Type {
+	case "", "object", "array", "string", "boolean", "null", "number", "integer":
+	default:
+		return fmt.Errorf("schema: unsupported type %q", p.Type)
+	}
+	*s = Schema(p)
+	return nil
+}
+
+// validate checks v against the schema, appending one message per violation
+func (s *Schema) validate(path string, v interface{}, errs []string) []string {
+	if s.Type != "" && !matchesType(s.Type, v) {
+		return append(errs, fmt.Sprintf("%s: expected %s", path, s.Type))
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: value not in enum", path))
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				errs = prop.validate(path+"."+name, val[name], errs)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, fmt.Sprintf("%s: unexpected property %q", path, name))
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range val {
+				errs = s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			errs = append(errs, fmt.Sprintf("%s: %v is less than minimum %v", path, val, *s.Minimum))
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			errs = append(errs, fmt.Sprintf("%s: %v is greater than maximum %v", path, val, *s.Maximum))
+		}
+	case string:
+		if s.MinLength != nil && len(val) < *s.MinLength {
+			errs = append(errs, fmt.Sprintf("%s: shorter than minLength %d", path, *s.MinLength))
+		}
+		if s.MaxLength != nil && len(val) > *s.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s: longer than maxLength %d", path, *s.MaxLength))
+		}
+	}
+	return errs
+}
+
+// matchesType reports whether a decoded JSON value has the named schema type
+func matchesType(t string, v interface{}) bool {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return t == "object"
+	case []interface{}:
+		return t == "array"
+	case string:
+		return t == "string"
+	case bool:
+		return t == "boolean"
+	case nil:
+		return t == "null"
+	case float64:
+		return t == "number" || (t == "integer" && val == float64(int64(val)))
+	}
+	return false
+}
+
+// schemaRegistry maps key prefixes to the schema their values must satisfy
+type schemaRegistry struct {
+	mu      sync.RWMutex
+	schemas map[string]*Schema
+}
+
+var schemas = &schemaRegistry{schemas: make(map[string]*Schema)}
+
+// register sets the schema for prefix, replacing any existing one
+func (r *schemaRegistry) register(prefix string, s *Schema) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas[prefix] = s
+}
+
+// replace swaps in schemas, keyed by prefix, as the full set
+func (r *schemaRegistry) replace(schemas map[string]*Schema) {
+	r.mu.Lock()
+	r.schemas = schemas
+	r.mu.Unlock()
+}
+
+// unregister removes the schema for prefix, reporting whether one was set
+func (r *schemaRegistry) unregister(prefix string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.schemas[prefix]
+	delete(r.schemas, prefix)
+	return ok
+}
+
+// schemaFor returns the schema of the longest prefix matching key, if any
+func (r *schemaRegistry) schemaFor(key string) *Schema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var schema *Schema
+	longest := -1
+	for prefix, s := range r.schemas {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			schema, longest = s, len(prefix)
+		}
+	}
+	return schema
+}
+
+// has reports whether values of key must satisfy a schema
+func (r *schemaRegistry) has(key string) bool {
+	return r.schemaFor(key) != nil
+}
+
+// validate checks value against the schema of the longest prefix matching
+// key; keys without a schema always pass
+func (r *schemaRegistry) validate(key, value string) []string {
+	schema := r.schemaFor(key)
+	if schema == nil {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return []string{"$: value is not valid JSON"}
+	}
+	return schema.validate("$", v, nil)
+}
+
+// writeSchemaErrors responds with the validation errors of a rejected value
+func writeSchemaErrors(w http.ResponseWriter, errs []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   "value does not match schema",
+		"details": errs,
+	})
+}
+
+// handleAddSchema handles the HTTP POST request to register a schema for a key prefix
+func handleAddSchema(w http.ResponseWriter, r *http.Request) {
+	type SchemaRequest struct {
+		Prefix string  `json:"prefix"`
+		Schema *Schema `json:"schema"`
+	}
+
+	var req SchemaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Schema == nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !canonicalizePrefixes(w, &req.Prefix) {
+		return
+	}
+	schemas.register(req.Prefix, req.Schema)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleRemoveSchema handles the HTTP DELETE request to remove the schema of a key prefix
+func handleRemoveSchema(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if !canonicalizePrefixes(w, &prefix) {
+		return
+	}
+	if !schemas.unregister(prefix) {
+		http.Error(w, "Schema not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
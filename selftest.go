@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"lrucache/clocktest"
+)
+
+// selfTest is one check of the startup self-test; it returns an error on failure
+type selfTest struct {
+	name string
+	run  func() error
+}
+
+var selfTests = []selfTest{
+	{"capacity enforcement", testCapacity},
+	{"lru order", testLRUOrder},
+	{"ttl expiry", testTTL},
+	{"removal callbacks", testRemovalCallbacks},
+	{"dependencies", testDependencies},
+	{"concurrency smoke", testConcurrency},
+}
+
+// runSelfTest runs the correctness checks and a micro-benchmark, writing a
+// report to w. It returns the process exit code: 0 if every check passed.
+func runSelfTest(w io.Writer) int {
+	failed := 0
+	for _, t := range selfTests {
+		start := time.Now()
+		if err := t.run(); err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %-22s %v\n", t.name, err)
+			continue
+		}
+		fmt.Fprintf(w, "ok   %-22s %v\n", t.name, time.Since(start).Round(time.Microsecond))
+	}
+
+	fmt.Fprintln(w)
+	for _, b := range []struct {
+		name string
+		op   func(c *LRUCache, i int)
+	}{
+		{"set", func(c *LRUCache, i int) { c.Set(strconv.Itoa(i%4096), "value", time.Minute) }},
+		{"get", func(c *LRUCache, i int) { c.Get(strconv.Itoa(i % 4096)) }},
+	} {
+		c := NewLRUCache(1024)
+		for i := 0; i < 4096; i++ {
+			c.Set(strconv.Itoa(i), "value", time.Minute)
+		}
+		const n = 1000000
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			b.op(c, i)
+		}
+		elapsed := time.Since(start)
+		fmt.Fprintf(w, "bench %-4s %10.0f ops/s %8.1f ns/op\n", b.name, n/elapsed.Seconds(), float64(elapsed.Nanoseconds())/n)
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(w, "\n%d of %d checks failed\n", failed, len(selfTests))
+		return 1
+	}
+	return 0
+}
+
+// testCapacity checks that no eviction policy lets the cache outgrow its capacity
+func testCapacity() error {
+	for _, policy := range []string{"lru", "gdsf", "lirs", "arc", "sampled"} {
+		c := NewLRUCache(100)
+		if err := c.SetPolicy(EvictionConfig{Policy: policy}); err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			c.Set(strconv.Itoa(i), "v", time.Minute)
+			c.Get(strconv.Itoa(i / 2))
+			if c.Len() > 100 {
+				return fmt.Errorf("%s: %d items in a cache of 100", policy, c.Len())
+			}
+		}
+		if c.Len() != 100 {
+			return fmt.Errorf("%s: %d items after filling a cache of 100", policy, c.Len())
+		}
+	}
+	return nil
+}
+
+// testLRUOrder checks that the least recently used item is evicted first
+func testLRUOrder() error {
+	c := NewLRUCache(2)
+	c.Set("a", "1", time.Minute)
+	c.Set("b", "2", time.Minute)
+	c.Get("a")
+	c.Set("c", "3", time.Minute)
+	if _, ok := c.Get("b"); ok {
+		return fmt.Errorf("b should have been evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		return fmt.Errorf("a should have been kept")
+	}
+	if !c.WasRecentlyEvicted("b") {
+		return fmt.Errorf("b should be reported as evicted")
+	}
+	return nil
+}
+
+// testTTL checks expiry against a fake clock
+func testTTL() error {
+	clk := clocktest.NewFake(time.Unix(0, 0))
+	c := NewLRUCacheWithClock(10, clk)
+	c.Set("k", "v", 10*time.Second)
+
+	clk.Advance(9 * time.Second)
+	if _, ok := c.Get("k"); !ok {
+		return fmt.Errorf("expired before its ttl")
+	}
+	clk.Advance(2 * time.Second)
+	if _, ok := c.Get("k"); ok {
+		return fmt.Errorf("still present after its ttl")
+	}
+	if reason := c.MissReason("k"); reason != MissExpired {
+		return fmt.Errorf("miss reason %q, want %q", reason, MissExpired)
+	}
+	if c.Len() != 0 {
+		return fmt.Errorf("expired item still counted")
+	}
+	return nil
+}
+
+// testRemovalCallbacks checks every removal path reports the right reason
+func testRemovalCallbacks() error {
+	clk := clocktest.NewFake(time.Unix(0, 0))
+	c := NewLRUCacheWithClock(2, clk)
+	got := map[string]RemovalReason{}
+	record := func(key string) func(RemovalReason) {
+		return func(r RemovalReason) { got[key] = r }
+	}
+
+	c.SetWithCallback("replaced", "1", time.Minute, record("replaced"))
+	c.Set("replaced", "2", time.Minute)
+	c.SetWithCallback("deleted", "1", time.Minute, record("deleted"))
+	c.Delete("deleted")
+	c.SetWithCallback("expired", "1", time.Second, record("expired"))
+	clk.Advance(2 * time.Second)
+	c.Get("expired")
+	c.SetWithCallback("evicted", "1", time.Minute, record("evicted"))
+	c.Set("x", "1", time.Minute)
+	c.Set("y", "1", time.Minute)
+
+	want := map[string]RemovalReason{
+		"replaced": RemovalReplaced,
+		"deleted":  RemovalDeleted,
+		"expired":  RemovalExpired,
+		"evicted":  RemovalEvicted,
+	}
+	for key, reason := range want {
+		if got[key] != reason {
+			return fmt.Errorf("%s: callback reason %v, want %v", key, got[key], reason)
+		}
+	}
+	return nil
+}
+
+// testDependencies checks writes and deletions cascade to dependents
+// transitively while expiry does not
+func testDependencies() error {
+	clk := clocktest.NewFake(time.Unix(0, 0))
+	c := NewLRUCacheWithClock(16, clk)
+	deps := func(keys ...string) SetOptions { return SetOptions{DependsOn: keys} }
+
+	c.Set("user", "1", time.Minute)
+	c.SetWithOptions("profile", "1", time.Minute, deps("user"))
+	c.SetWithOptions("page", "1", time.Minute, deps("profile"))
+	c.Set("user", "2", time.Minute)
+	for _, key := range []string{"profile", "page"} {
+		if _, ok := c.Get(key); ok {
+			return fmt.Errorf("%s survived an update of user", key)
+		}
+	}
+
+	c.Set("user", "3", time.Minute)
+	c.SetWithOptions("profile", "1", time.Minute, deps("user"))
+	c.SetWithOptions("page", "1", time.Minute, deps("profile", "user"))
+	c.Delete("user")
+	for _, key := range []string{"profile", "page"} {
+		if _, ok := c.Get(key); ok {
+			return fmt.Errorf("%s survived the deletion of user", key)
+		}
+	}
+
+	// Writing the second half of a cycle invalidates the first
+	c.SetWithOptions("a", "1", time.Minute, deps("b"))
+	c.SetWithOptions("b", "1", time.Minute, deps("a"))
+	if _, ok := c.Get("a"); ok {
+		return fmt.Errorf("a survived the write of b")
+	}
+	c.Delete("b")
+
+	c.Set("short", "1", time.Second)
+	c.SetWithOptions("derived", "1", time.Minute, deps("short"))
+	clk.Advance(2 * time.Second)
+	c.Get("short")
+	if _, ok := c.Get("derived"); !ok {
+		return fmt.Errorf("derived removed by the expiry of short")
+	}
+	if st := c.Stats(); st.Invalidations != 5 {
+		return fmt.Errorf("%d invalidations counted, want 5", st.Invalidations)
+	}
+	return nil
+}
+
+// testConcurrency hammers one cache from many goroutines and checks its
+// invariants afterwards
+func testConcurrency() error {
+	c := NewLRUCache(256)
+	const workers, ops = 16, 20000
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				key := strconv.Itoa((w*ops + i) % 1024)
+				switch i % 4 {
+				case 0, 1:
+					c.Set(key, "v", time.Minute)
+				case 2:
+					c.Get(key)
+				case 3:
+					c.Delete(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if c.Len() > 256 {
+		return fmt.Errorf("%d items in a cache of 256", c.Len())
+	}
+	st := c.Stats()
+	if want := uint64(workers * ops / 2); st.Sets != want {
+		return fmt.Errorf("%d sets counted, want %d", st.Sets, want)
+	}
+	if want := uint64(workers * ops / 4); st.Hits+st.Misses != want {
+		return fmt.Errorf("%d gets counted, want %d", st.Hits+st.Misses, want)
+	}
+	items, _ := c.Snapshot()
+	if len(items) != c.Len() {
+		return fmt.Errorf("snapshot has %d items, cache reports %d", len(items), c.Len())
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// shadowCache is a ghost LRU that tracks keys only, used to estimate the hit
+// ratio the live cache would have at a different capacity. It always
+// simulates LRU, whatever the live eviction policy, so under another policy
+// it estimates what LRU would do at that capacity.
+type shadowCache struct {
+	capacity int
+	items    map[string]*list.Element
+	ll       *list.List
+	hits     uint64
+	misses   uint64
+}
+
+// ShadowStats reports the hypothetical performance of a shadow cache
+type ShadowStats struct {
+	Capacity int     `json:"capacity"`
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// shadowSet mirrors live traffic into a group of shadow caches
+type shadowSet struct {
+	mu     sync.Mutex
+	caches []*shadowCache
+}
+
+// newShadowSet creates one shadow cache per capacity
+func newShadowSet(capacities []int) *shadowSet {
+	s := &shadowSet{}
+	for _, capacity := range capacities {
+		s.caches = append(s.caches, &shadowCache{
+			capacity: capacity,
+			items:    make(map[string]*list.Element),
+			ll:       list.New(),
+		})
+	}
+	return s
+}
+
+// get records a read of key in every shadow cache
+func (s *shadowSet) get(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sc := range s.caches {
+		if ele, ok := sc.items[key]; ok {
+			sc.ll.MoveToFront(ele)
+			sc.hits++
+		} else {
+			sc.misses++
+		}
+	}
+}
+
+// set records a write of key in every shadow cache
+func (s *shadowSet) set(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sc := range s.caches {
+		if ele, ok := sc.items[key]; ok {
+			sc.ll.MoveToFront(ele)
+			continue
+		}
+		sc.items[key] = sc.ll.PushFront(key)
+		if sc.ll.Len() > sc.capacity {
+			oldest := sc.ll.Back()
+			sc.ll.Remove(oldest)
+			delete(sc.items, oldest.Value.(string))
+		}
+	}
+}
+
+// remove drops key from every shadow cache. It mirrors deletes, expirations,
+// invalidations and purges, which remove a key whatever the capacity, but
+// not evictions, which each shadow cache makes for itself.
+func (s *shadowSet) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sc := range s.caches {
+		if ele, ok := sc.items[key]; ok {
+			sc.ll.Remove(ele)
+			delete(sc.items, key)
+		}
+	}
+}
+
+// stats returns the hit ratios of every shadow cache
+func (s *shadowSet) stats() []ShadowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]ShadowStats, 0, len(s.caches))
+	for _, sc := range s.caches {
+		st := ShadowStats{Capacity: sc.capacity, Hits: sc.hits, Misses: sc.misses}
+		if total := sc.hits + sc.misses; total > 0 {
+			st.HitRatio = float64(sc.hits) / float64(total)
+		}
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+// handleShadow handles the HTTP GET request reporting shadow cache hit ratios
+func handleShadow(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(cache.shadows.stats())
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"lrucache/clocktest"
+)
+
+// simEpoch is the virtual time at which simulations start
+var simEpoch = time.Unix(0, 0).UTC()
+
+// Op is one cache operation in a simulation script or operation log, one
+// JSON object per line. T is the virtual time in milliseconds since the
+// start, fractions allowed; the clock only moves between operations. An "init" op sets the
+// capacity, policy and seed and must come first if present. For
+// expire_prefix, Key holds the prefix.
+type Op struct {
+	T        float64 `json:"t"`
+	Op       string  `json:"op"` // init, set, add, get, mget, delete, resize, policy, expire_prefix
+	Key      string  `json:"key,omitempty"`
+	Value    string  `json:"value,omitempty"`
+	TTL      float64 `json:"ttl_ms,omitempty"` // Omitted uses the default TTL, negative never expires
+	Cost     float64 `json:"cost,omitempty"`
+	Capacity int     `json:"capacity,omitempty"`
+	Policy   string  `json:"policy,omitempty"`
+	Seed     int64   `json:"seed,omitempty"`
+	Extend   bool    `json:"extend,omitempty"`
+	Found    *bool   `json:"found,omitempty"` // Result of get and mget, and of add (whether it stored)
+}
+
+// simulation replays ops deterministically against a cache on a virtual clock
+type simulation struct {
+	clock *clocktest.Fake
+	cache *LRUCache
+	out   io.Writer
+}
+
+// newSimulation creates a simulation writing its trace to out
+func newSimulation(out io.Writer) *simulation {
+	clk := clocktest.NewFake(simEpoch)
+	c := NewLRUCacheWithClock(1024, clk)
+	c.Seed(0)
+	return &simulation{clock: clk, cache: c, out: out}
+}
+
+// runSimulation runs the script read from r, writing a trace of every op
+// and its result, every removal with its reason and the final stats to w
+func runSimulation(r io.Reader, w io.Writer) error {
+	sim := newSimulation(w)
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for n := 1; ; n++ {
+		var op Op
+		err := dec.Decode(&op)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("op %d: %w", n, err)
+		}
+		if _, err := sim.apply(op); err != nil {
+			return fmt.Errorf("op %d: %w", n, err)
+		}
+	}
+
+	st, _ := json.Marshal(sim.cache.Stats())
+	fmt.Fprintf(w, "stats %s\n", st)
+	return nil
+}
+
+// apply advances the clock to op's time and executes it, returning the
+// observed result for gets and adds
+func (s *simulation) apply(op Op) (*bool, error) {
+	at := simEpoch.Add(time.Duration(op.T * float64(time.Millisecond)))
+	if at.Before(s.clock.Now()) {
+		return nil, fmt.Errorf("time goes backwards at t=%g", op.T)
+	}
+	s.clock.Set(at)
+
+	ttl := time.Duration(op.TTL * float64(time.Millisecond))
+	var found *bool
+	if (op.Op == "init" || op.Op == "resize") && op.Capacity < 1 {
+		return nil, fmt.Errorf("%s: capacity must be at least 1, got %d", op.Op, op.Capacity)
+	}
+	switch op.Op {
+	case "init":
+		s.cache.Resize(op.Capacity)
+		if err := s.cache.SetPolicy(EvictionConfig{Policy: op.Policy}); err != nil {
+			return nil, err
+		}
+		s.cache.Seed(op.Seed)
+	case "set":
+		s.cache.setWithTrace(op.Key, op.Value, ttl, op.Cost, s.removed(op.Key))
+	case "add":
+		ok := s.cache.Add(op.Key, op.Value, ttl)
+		found = &ok
+	case "get":
+		_, ok := s.cache.Get(op.Key)
+		found = &ok
+	case "delete":
+		s.cache.Delete(op.Key)
+	case "mget":
+		// a single-key mget, which resets the expiration on a hit if ttl is set
+		_, ok := s.cache.MGet([]string{op.Key}, ttl)[op.Key]
+		found = &ok
+	case "resize":
+		s.cache.Resize(op.Capacity)
+	case "policy":
+		if err := s.cache.SetPolicy(EvictionConfig{Policy: op.Policy}); err != nil {
+			return nil, err
+		}
+	case "expire_prefix":
+		s.cache.ExpirePrefix(op.Key, ttl, op.Extend, func(string) bool { return true })
+	default:
+		return nil, fmt.Errorf("unknown op %q", op.Op)
+	}
+
+	line := fmt.Sprintf("t=%-10.3f %-13s %s", op.T, op.Op, op.Key)
+	if found != nil {
+		line += fmt.Sprintf(" found=%t", *found)
+		if !*found && op.Op != "add" {
+			line += " reason=" + s.cache.MissReason(op.Key)
+		}
+	}
+	fmt.Fprintln(s.out, line)
+	return found, nil
+}
+
+// removed returns a removal callback tracing why key left the cache
+func (s *simulation) removed(key string) func(RemovalReason) {
+	return func(reason RemovalReason) {
+		fmt.Fprintf(s.out, "t=%-10.3f   removed     %s reason=%s\n", msSince(simEpoch, s.clock.Now()), key, reason)
+	}
+}
+
+// msSince returns the milliseconds from start to t
+func msSince(start, t time.Time) float64 {
+	return durationMs(t.Sub(start))
+}
+
+// durationMs converts d to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// setWithTrace stores a value with both a cost and a removal callback
+func (c *LRUCache) setWithTrace(key, value string, exp time.Duration, cost float64, onRemove func(RemovalReason)) {
+	c.lock()
+	defer c.unlock()
+	c.set(key, value, exp, entryOptions{cost: cost, onRemove: onRemove})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultSlowLogLen is how many entries the slow log keeps when none is set
+const defaultSlowLogLen = 128
+
+// SlowLogEntry describes one cache operation that exceeded the threshold
+type SlowLogEntry struct {
+	ID         uint64    `json:"id"`
+	Time       time.Time `json:"time"`
+	Op         string    `json:"op"`
+	Key        string    `json:"key"`         // Space-separated for multi-key operations
+	Size       int       `json:"size"`        // Bytes of the values under the keys once the operation ran
+	DurationUs int64     `json:"duration_us"` // Lock wait plus execution
+	LockWaitUs int64     `json:"lock_wait_us"`
+	ExecUs     int64     `json:"exec_us"`
+	Redacted   bool      `json:"redacted,omitempty"` // Key withheld from a caller not allowed to read it
+
+	keys []string
+}
+
+// slowLog keeps the most recent slow operations in a ring buffer, like
+// Redis's SLOWLOG
+type slowLog struct {
+	threshold int64 // Nanoseconds, read atomically; 0 disables the log
+
+	mu      sync.Mutex
+	entries []SlowLogEntry
+	next    int // Index of the oldest entry, overwritten next once entries is full
+	maxLen  int
+	lastID  uint64
+}
+
+// newSlowLog creates a disabled slow log
+func newSlowLog() *slowLog {
+	return &slowLog{maxLen: defaultSlowLogLen}
+}
+
+// configure sets the threshold and length, dropping the oldest entries if
+// the log shrinks
+func (l *slowLog) configure(cfg SlowLogConfig) {
+	atomic.StoreInt64(&l.threshold, int64(cfg.ThresholdUs)*int64(time.Microsecond))
+	maxLen := cfg.MaxLen
+	if maxLen <= 0 {
+		maxLen = defaultSlowLogLen
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := l.newestFirst(maxLen)
+	l.entries = l.entries[:0]
+	for i := len(entries) - 1; i >= 0; i-- {
+		l.entries = append(l.entries, entries[i])
+	}
+	l.next = 0
+	l.maxLen = maxLen
+}
+
+// enabled reports whether operations are being timed
+func (l *slowLog) enabled() bool {
+	return atomic.LoadInt64(&l.threshold) > 0
+}
+
+// slow reports whether an operation taking d is logged
+func (l *slowLog) slow(d time.Duration) bool {
+	t := atomic.LoadInt64(&l.threshold)
+	return t > 0 && int64(d) >= t
+}
+
+// add records an entry, overwriting the oldest once the log is full
+func (l *slowLog) add(e SlowLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastID++
+	e.ID = l.lastID
+	if len(l.entries) < l.maxLen {
+		l.entries = append(l.entries, e)
+		return
+	}
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % l.maxLen
+}
+
+// newestFirst returns up to n entries, most recent first; the caller must hold l.mu
+func (l *slowLog) newestFirst(n int) []SlowLogEntry {
+	n = min(n, len(l.entries))
+	out := make([]SlowLogEntry, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, l.entries[(l.next-1-i+len(l.entries))%len(l.entries)])
+	}
+	return out
+}
+
+// get returns up to n entries, most recent first
+func (l *slowLog) get(n int) []SlowLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.newestFirst(n)
+}
+
+// reset empties the log
+func (l *slowLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+	l.next = 0
+}
+
+// observe records the execution time of op and logs it to the slow log if,
+// with the lock wait, it exceeded the threshold. It must be called with the
+// lock held, after the operation ran.
+func (c *LRUCache) observe(op string, start time.Time, keys ...string) {
+	exec := time.Since(start)
+	c.latency.ops[op].observe(exec)
+	if !c.slowlog.slow(exec + c.lockWait) {
+		return
+	}
+
+	size := 0
+	for _, key := range keys {
+		if ele, ok := c.items[key]; ok {
+			size += len(ele.Value.(*CacheItem).Value)
+		}
+	}
+	c.slowlog.add(SlowLogEntry{
+		Time:       time.Now(),
+		Op:         op,
+		Key:        strings.Join(keys, " "),
+		keys:       append([]string(nil), keys...),
+		Size:       size,
+		DurationUs: (exec + c.lockWait).Microseconds(),
+		LockWaitUs: c.lockWait.Microseconds(),
+		ExecUs:     exec.Microseconds(),
+	})
+}
+
+// handleSlowLog handles the HTTP GET request listing the slowest recent
+// operations, newest first, e.g. /slowlog?count=10. Entries touching a key
+// the caller may not read keep their timings but have the keys redacted.
+func handleSlowLog(w http.ResponseWriter, r *http.Request) {
+	count := defaultSlowLogLen
+	if v := r.URL.Query().Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid count", http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+	entries := cache.slowlog.get(count)
+	for i := range entries {
+		for _, key := range entries[i].keys {
+			if !keyAllowed(r, key, AccessRead) {
+				entries[i].Key, entries[i].Redacted = "", true
+				break
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+// handleResetSlowLog handles the HTTP DELETE request emptying the slow log
+func handleResetSlowLog(w http.ResponseWriter, r *http.Request) {
+	cache.slowlog.reset()
+	w.WriteHeader(http.StatusOK)
+}
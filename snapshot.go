@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// snapshotFormat is the version of the snapshot layout written by this build.
+// Any change to the records of exports or handoff streams must bump it and
+// register a migration from the previous format.
+const snapshotFormat = 3
+
+// snapshotMigrations upgrade a record written in format f to format f+1,
+// keyed by f. Format 0 is a stream without a header.
+var snapshotMigrations = map[int]func(record json.RawMessage) (json.RawMessage, error){
+	// Format 1 added the header and left the records unchanged
+	0: func(record json.RawMessage) (json.RawMessage, error) { return record, nil },
+	// Format 2 allows a zero expiration for entries that never expire, which
+	// format 1 records never have
+	1: func(record json.RawMessage) (json.RawMessage, error) { return record, nil },
+	// Format 3 added redacted records without a value, which format 2
+	// streams never have; older builds must refuse them rather than import
+	// empty values
+	2: func(record json.RawMessage) (json.RawMessage, error) { return record, nil },
+}
+
+// SnapshotHeader is the first record of a snapshot stream, identifying its
+// layout and the build that wrote it
+type SnapshotHeader struct {
+	Format    int       `json:"format"`
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	BuildDate string    `json:"build_date,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Token     uint64    `json:"token,omitempty"` // Write token the records reflect
+}
+
+// newSnapshotHeader returns the header for a snapshot written now by this build
+func newSnapshotHeader() SnapshotHeader {
+	b := buildInfo()
+	return SnapshotHeader{
+		Format:    snapshotFormat,
+		Version:   b.Version,
+		Commit:    b.Commit,
+		BuildDate: b.BuildDate,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// check reports an error if the snapshot was written in a newer format than
+// this build understands, or one it has no migration from
+func (h SnapshotHeader) check() error {
+	if h.Format > snapshotFormat {
+		return fmt.Errorf("snapshot format %d written by %s is newer than the supported format %d", h.Format, h.Version, snapshotFormat)
+	}
+	for f := h.Format; f < snapshotFormat; f++ {
+		if snapshotMigrations[f] == nil {
+			return fmt.Errorf("no migration from snapshot format %d", f)
+		}
+	}
+	return nil
+}
+
+// parseSnapshotHeader decodes raw as a snapshot header, reporting false if it
+// is some other record
+func parseSnapshotHeader(raw []byte) (SnapshotHeader, bool) {
+	var h struct {
+		SnapshotHeader
+		Format *int `json:"format"`
+	}
+	if err := json.Unmarshal(raw, &h); err != nil || h.Format == nil {
+		return SnapshotHeader{}, false
+	}
+	h.SnapshotHeader.Format = *h.Format
+	return h.SnapshotHeader, true
+}
+
+// snapshotReader decodes the records of a snapshot stream, migrating those
+// written in an older format to the current one
+type snapshotReader struct {
+	Header  SnapshotHeader
+	dec     *json.Decoder
+	pending json.RawMessage // First record of a stream without a header
+}
+
+// newSnapshotReader reads the header from r, refusing formats it cannot
+// migrate. A stream without a header is read as format 0.
+func newSnapshotReader(r io.Reader) (*snapshotReader, error) {
+	s := &snapshotReader{dec: json.NewDecoder(r)}
+	var raw json.RawMessage
+	if err := s.dec.Decode(&raw); err != nil {
+		if errors.Is(err, io.EOF) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if header, ok := parseSnapshotHeader(raw); ok {
+		s.Header = header
+	} else {
+		s.pending = raw
+	}
+	if err := s.Header.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// next decodes the next record into v, returning io.EOF after the last one
+func (s *snapshotReader) next(v interface{}) error {
+	raw := s.pending
+	s.pending = nil
+	if raw == nil {
+		if err := s.dec.Decode(&raw); err != nil {
+			return err
+		}
+	}
+	for f := s.Header.Format; f < snapshotFormat; f++ {
+		var err error
+		if raw, err = snapshotMigrations[f](raw); err != nil {
+			return fmt.Errorf("migrating snapshot record from format %d: %w", f, err)
+		}
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Stats holds the cache operation counters
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Sets        uint64 `json:"sets"`
+	Deletes     uint64 `json:"deletes"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	// Sets that overwrote an existing value
+	Replacements uint64 `json:"replacements"`
+	// Writes rejected for exceeding the maximum value size
+	TooLarge uint64 `json:"too_large"`
+	// Entries removed because an entry they depend on changed
+	Invalidations uint64 `json:"invalidations"`
+}
+
+// removals breaks the removal counters down by RemovalReason
+func (s Stats) removals() map[string]uint64 {
+	return map[string]uint64{
+		RemovalExpired.String():     s.Expirations,
+		RemovalEvicted.String():     s.Evictions,
+		RemovalReplaced.String():    s.Replacements,
+		RemovalDeleted.String():     s.Deletes,
+		RemovalInvalidated.String(): s.Invalidations,
+	}
+}
+
+// PatternStats holds the counters tracked for one registered key pattern
+type PatternStats struct {
+	Pattern   string  `json:"pattern"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"`
+	HitRatio  float64 `json:"hit_ratio"`
+}
+
+// patternTracker keeps separate stats for keys matching registered glob patterns
+type patternTracker struct {
+	mu       sync.Mutex
+	patterns []*PatternStats
+}
+
+// newPatternTracker creates a tracker with no patterns
+func newPatternTracker() *patternTracker {
+	return &patternTracker{}
+}
+
+// add registers a glob pattern, canonicalized like a key prefix;
+// registering an existing pattern is a no-op
+func (t *patternTracker) add(pattern string) error {
+	pattern, err := canonicalPrefix(pattern)
+	if err != nil {
+		return err
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range t.patterns {
+		if p.Pattern == pattern {
+			return nil
+		}
+	}
+	t.patterns = append(t.patterns, &PatternStats{Pattern: pattern})
+	return nil
+}
+
+// remove unregisters a pattern, reporting whether it was registered
+func (t *patternTracker) remove(pattern string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, p := range t.patterns {
+		if p.Pattern == pattern {
+			t.patterns = append(t.patterns[:i], t.patterns[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// recordGet counts a lookup of key against every matching pattern
+func (t *patternTracker) recordGet(key string, hit bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range t.patterns {
+		if ok, _ := path.Match(p.Pattern, key); ok {
+			if hit {
+				p.Hits++
+			} else {
+				p.Misses++
+			}
+		}
+	}
+}
+
+// recordEviction counts an eviction of key against every matching pattern
+func (t *patternTracker) recordEviction(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range t.patterns {
+		if ok, _ := path.Match(p.Pattern, key); ok {
+			p.Evictions++
+		}
+	}
+}
+
+// snapshot returns a copy of every pattern's stats
+func (t *patternTracker) snapshot() []PatternStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := make([]PatternStats, 0, len(t.patterns))
+	for _, p := range t.patterns {
+		st := *p
+		if total := st.Hits + st.Misses; total > 0 {
+			st.HitRatio = float64(st.Hits) / float64(total)
+		}
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+// Stats returns a copy of the cache counters
+func (c *LRUCache) Stats() Stats {
+	c.lock()
+	defer c.unlock()
+	return c.stats
+}
+
+// ItemSize is the value size of one entry
+type ItemSize struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+}
+
+// LargestItems returns the n unexpired entries with the largest values,
+// largest first
+func (c *LRUCache) LargestItems(n int) []ItemSize {
+	c.lock()
+	defer c.unlock()
+
+	now := c.clock.Now()
+	largest := make([]ItemSize, 0, n+1)
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		item := e.Value.(*CacheItem)
+		if item.expired(now) || (len(largest) == n && len(item.Value) <= largest[n-1].Size) {
+			continue
+		}
+		i := sort.Search(len(largest), func(i int) bool { return largest[i].Size < len(item.Value) })
+		largest = append(largest, ItemSize{})
+		copy(largest[i+1:], largest[i:])
+		largest[i] = ItemSize{Key: item.Key, Size: len(item.Value)}
+		if len(largest) > n {
+			largest = largest[:n]
+		}
+	}
+	return largest
+}
+
+// handleLargeItems handles the HTTP GET request reporting the largest entries
+// (count, default 10, at most 100) against the maximum value size
+func handleLargeItems(w http.ResponseWriter, r *http.Request) {
+	type LargeItemsResponse struct {
+		MaxValueSize int        `json:"max_value_size"` // 0 if unlimited
+		TooLarge     uint64     `json:"too_large"`
+		Largest      []ItemSize `json:"largest"`
+	}
+
+	count := 10
+	if v := r.URL.Query().Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 100 {
+			http.Error(w, "Invalid count", http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+
+	resp := LargeItemsResponse{
+		MaxValueSize: cache.MaxValueSize(),
+		TooLarge:     cache.Stats().TooLarge,
+		Largest:      []ItemSize{},
+	}
+	for _, item := range cache.LargestItems(count) {
+		if keyAllowed(r, item.Key, AccessRead) {
+			resp.Largest = append(resp.Largest, item)
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleStats handles the HTTP GET request reporting cache statistics
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	type StatsResponse struct {
+		Stats
+		Removals map[string]uint64         `json:"removals"`
+		Size     int                       `json:"size"`
+		Capacity int                       `json:"capacity"`
+		Patterns []PatternStats            `json:"patterns"`
+		Latency  map[string]LatencySummary `json:"latency"`
+		Lock     *ContentionStats          `json:"lock,omitempty"`
+		QoS      QoSStats                  `json:"qos"`
+		Limits   LimitStats                `json:"limits"`
+		Policy   string                    `json:"policy"`
+		// Policy-specific state, e.g. the GDSF inflation value
+		PolicyStats interface{} `json:"policy_stats,omitempty"`
+	}
+
+	st := cache.Stats()
+	resp := StatsResponse{
+		Stats:    st,
+		Removals: st.removals(),
+		Size:     cache.Len(),
+		Capacity: cache.Capacity(),
+		Patterns: cache.patterns.snapshot(),
+		Latency:  cache.latency.summaries(),
+		QoS:      qos.stats(),
+		Limits:   limits.stats(),
+	}
+	if cache.contention != nil {
+		lock := cache.contention.stats()
+		resp.Lock = &lock
+	}
+	resp.Policy, resp.PolicyStats = cache.PolicyStats()
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleMetrics handles the HTTP GET request exposing statistics in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	st := cache.Stats()
+	writeMetric(w, "lrucache_hits_total", "counter", st.Hits)
+	writeMetric(w, "lrucache_misses_total", "counter", st.Misses)
+	writeMetric(w, "lrucache_sets_total", "counter", st.Sets)
+	writeMetric(w, "lrucache_deletes_total", "counter", st.Deletes)
+	writeMetric(w, "lrucache_evictions_total", "counter", st.Evictions)
+	writeMetric(w, "lrucache_expirations_total", "counter", st.Expirations)
+	writeMetric(w, "lrucache_too_large_total", "counter", st.TooLarge)
+	writeMetric(w, "lrucache_invalidations_total", "counter", st.Invalidations)
+	fmt.Fprintln(w, "# TYPE lrucache_removals_total counter")
+	for _, reason := range []RemovalReason{RemovalExpired, RemovalEvicted, RemovalReplaced, RemovalDeleted, RemovalInvalidated} {
+		fmt.Fprintf(w, "lrucache_removals_total{reason=%q} %d\n", reason.String(), st.removals()[reason.String()])
+	}
+	writeMetric(w, "lrucache_size", "gauge", cache.Len())
+	writeMetric(w, "lrucache_capacity", "gauge", cache.Capacity())
+	writeMetric(w, "lrucache_health_score", "gauge", health.Report().Score)
+
+	fmt.Fprintln(w, "# TYPE lrucache_op_duration_seconds summary")
+	for op, s := range cache.latency.summaries() {
+		fmt.Fprintf(w, "lrucache_op_duration_seconds{op=%q,quantile=\"0.5\"} %g\n", op, s.P50/1e6)
+		fmt.Fprintf(w, "lrucache_op_duration_seconds{op=%q,quantile=\"0.95\"} %g\n", op, s.P95/1e6)
+		fmt.Fprintf(w, "lrucache_op_duration_seconds{op=%q,quantile=\"0.99\"} %g\n", op, s.P99/1e6)
+		fmt.Fprintf(w, "lrucache_op_duration_seconds_count{op=%q} %d\n", op, s.Count)
+	}
+
+	if cache.contention != nil {
+		lock := cache.contention.stats()
+		writeMetric(w, "lrucache_lock_slow_waits_total", "counter", lock.SlowWaits)
+		fmt.Fprintln(w, "# TYPE lrucache_lock_wait_seconds summary")
+		fmt.Fprintf(w, "lrucache_lock_wait_seconds{quantile=\"0.5\"} %g\n", lock.LockWait.P50/1e6)
+		fmt.Fprintf(w, "lrucache_lock_wait_seconds{quantile=\"0.95\"} %g\n", lock.LockWait.P95/1e6)
+		fmt.Fprintf(w, "lrucache_lock_wait_seconds{quantile=\"0.99\"} %g\n", lock.LockWait.P99/1e6)
+		fmt.Fprintf(w, "lrucache_lock_wait_seconds_count %d\n", lock.LockWait.Count)
+	}
+
+	patterns := cache.patterns.snapshot()
+	if len(patterns) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# TYPE lrucache_pattern_hits_total counter")
+	for _, p := range patterns {
+		fmt.Fprintf(w, "lrucache_pattern_hits_total{pattern=%q} %d\n", p.Pattern, p.Hits)
+	}
+	fmt.Fprintln(w, "# TYPE lrucache_pattern_misses_total counter")
+	for _, p := range patterns {
+		fmt.Fprintf(w, "lrucache_pattern_misses_total{pattern=%q} %d\n", p.Pattern, p.Misses)
+	}
+	fmt.Fprintln(w, "# TYPE lrucache_pattern_evictions_total counter")
+	for _, p := range patterns {
+		fmt.Fprintf(w, "lrucache_pattern_evictions_total{pattern=%q} %d\n", p.Pattern, p.Evictions)
+	}
+}
+
+// writeMetric writes a single unlabelled metric with its type line
+func writeMetric(w http.ResponseWriter, name, kind string, value interface{}) {
+	fmt.Fprintf(w, "# TYPE %s %s\n%s %v\n", name, kind, name, value)
+}
+
+// handleAddPattern handles the HTTP POST request to start tracking stats for a key pattern
+func handleAddPattern(w http.ResponseWriter, r *http.Request) {
+	type PatternRequest struct {
+		Pattern string `json:"pattern"`
+	}
+
+	var req PatternRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Pattern == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := cache.patterns.add(req.Pattern); err != nil {
+		http.Error(w, "Invalid pattern", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleRemovePattern handles the HTTP DELETE request to stop tracking a key pattern
+func handleRemovePattern(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if !canonicalizePrefixes(w, &pattern) {
+		return
+	}
+	if !cache.patterns.remove(pattern) {
+		http.Error(w, "Pattern not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
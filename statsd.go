@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// statsdPacketSize keeps each datagram under a typical Ethernet MTU
+const statsdPacketSize = 1432
+
+// statsdEmitter pushes the cache counters to a StatsD or DogStatsD endpoint
+type statsdEmitter struct {
+	cache    *LRUCache
+	conn     net.Conn
+	interval time.Duration
+	prefix   string
+	tags     string // Preformatted DogStatsD tag suffix, empty without tags
+	last     Stats  // Counters at the previous flush, for sending deltas
+	// Latency histograms at the previous flush, so percentiles cover one interval
+	lastLatency map[string]*histogram
+}
+
+// newStatsdEmitter dials the configured address over UDP
+func newStatsdEmitter(c *LRUCache, cfg StatsDConfig) (*statsdEmitter, error) {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	interval := time.Duration(cfg.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "lrucache"
+	}
+	e := &statsdEmitter{
+		cache:    c,
+		conn:     conn,
+		interval: interval,
+		prefix:   strings.TrimSuffix(prefix, ".") + ".",
+		last:     c.Stats(),
+	}
+	e.lastLatency = make(map[string]*histogram, len(c.latency.ops))
+	for op, h := range c.latency.ops {
+		e.lastLatency[op] = h.clone()
+	}
+	if len(cfg.Tags) > 0 {
+		e.tags = "|#" + strings.Join(cfg.Tags, ",")
+	}
+	return e, nil
+}
+
+// run sends the metrics every interval until ctx is done
+func (e *statsdEmitter) run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	defer e.conn.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.flush(); err != nil {
+				logrus.Warnf("statsd: %v", err)
+			}
+		}
+	}
+}
+
+// flush sends the counter increments since the last flush, the size gauges
+// and the per-operation latency percentiles over the interval. Operations
+// not run during the interval send no percentiles.
+func (e *statsdEmitter) flush() error {
+	st := e.cache.Stats()
+	prev := e.last
+	e.last = st
+
+	var lines []string
+	counter := func(name string, cur, prev uint64) {
+		lines = append(lines, e.line(name, fmt.Sprint(cur-prev), "c", ""))
+	}
+	counter("hits", st.Hits, prev.Hits)
+	counter("misses", st.Misses, prev.Misses)
+	counter("sets", st.Sets, prev.Sets)
+	counter("deletes", st.Deletes, prev.Deletes)
+	counter("evictions", st.Evictions, prev.Evictions)
+	counter("expirations", st.Expirations, prev.Expirations)
+	counter("replacements", st.Replacements, prev.Replacements)
+	counter("too_large", st.TooLarge, prev.TooLarge)
+	counter("invalidations", st.Invalidations, prev.Invalidations)
+	lines = append(lines,
+		e.line("size", fmt.Sprint(e.cache.Len()), "g", ""),
+		e.line("capacity", fmt.Sprint(e.cache.Capacity()), "g", ""),
+	)
+	for op, h := range e.cache.latency.ops {
+		cur := h.clone()
+		d := cur.delta(e.lastLatency[op])
+		e.lastLatency[op] = cur
+		if d.total == 0 {
+			continue
+		}
+		s := d.summary()
+		tag := "op:" + op
+		lines = append(lines,
+			e.line("latency.p50_ms", fmt.Sprintf("%g", s.P50/1e3), "g", tag),
+			e.line("latency.p95_ms", fmt.Sprintf("%g", s.P95/1e3), "g", tag),
+			e.line("latency.p99_ms", fmt.Sprintf("%g", s.P99/1e3), "g", tag),
+		)
+	}
+	return e.send(lines)
+}
+
+// line formats one metric, adding extra to the configured tags
+func (e *statsdEmitter) line(name, value, kind, extra string) string {
+	tags := e.tags
+	switch {
+	case extra == "":
+	case tags == "":
+		tags = "|#" + extra
+	default:
+		tags += "," + extra
+	}
+	return e.prefix + name + ":" + value + "|" + kind + tags
+}
+
+// send writes the lines newline-separated, packing as many into each
+// datagram as fit
+func (e *statsdEmitter) send(lines []string) error {
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(l) > statsdPacketSize {
+			if _, err := e.conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(l)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	_, err := e.conn.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sdListenFDsStart is the first file descriptor passed by systemd
+const sdListenFDsStart = 3
+
+var (
+	activatedOnce sync.Once
+	activated     map[string]net.Listener // Sockets passed by systemd, keyed by name
+	unnamed       []net.Listener          // Sockets passed without a usable name
+)
+
+// loadActivatedListeners takes over the sockets passed by systemd socket
+// activation (LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES). Sockets named
+// after a listener go to that listener; the rest are handed out in order.
+func loadActivatedListeners() {
+	activated = make(map[string]net.Listener)
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		os.Unsetenv(upgradeSocketEnv)
+	}()
+
+	// A hot restart passes sockets the same way, but cannot know the new PID
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if (err != nil || pid != os.Getpid()) && os.Getenv(upgradeSocketEnv) == "" {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0; i < n; i++ {
+		fd := sdListenFDsStart + i
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			logrus.Errorf("activated socket %d: %v", fd, err)
+			continue
+		}
+
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		if _, dup := activated[name]; name == "" || name == "unknown" || dup {
+			unnamed = append(unnamed, ln)
+			continue
+		}
+		activated[name] = ln
+	}
+}
+
+// activatedListener returns the socket systemd passed for the named listener,
+// or nil if the listener should bind its own address
+func activatedListener(name string) net.Listener {
+	activatedOnce.Do(loadActivatedListeners)
+	if ln, ok := activated[name]; ok {
+		return ln
+	}
+	if len(unnamed) > 0 {
+		ln := unnamed[0]
+		unnamed = unnamed[1:]
+		return ln
+	}
+	return nil
+}
+
+// sdNotify sends a state update to systemd when running under a
+// Type=notify unit; it does nothing otherwise
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:] // abstract socket
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		logrus.Errorf("sd_notify: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		logrus.Errorf("sd_notify: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// streamingRoutes are the routes whose responses stay open and must be
+// flushed as they go, so they are never buffered or given a deadline
+var streamingRoutes = map[string]bool{"/events": true}
+
+// unbufferedRoutes send or receive bodies too large to hold in memory, or
+// set trailers, so their responses are written directly. Their deadline
+// only cancels the request context, which export and import check between
+// records.
+var unbufferedRoutes = map[string]bool{
+	"/export":           true,
+	"/import":           true,
+	"/import/redis":     true,
+	"/objects/{key:.+}": true,
+}
+
+var (
+	timeoutsMu    sync.RWMutex
+	routeTimeouts map[string]time.Duration // Per-route deadlines keyed by path template
+)
+
+// setRouteTimeouts replaces the per-route deadlines from a map of milliseconds
+func setRouteTimeouts(ms map[string]int) {
+	timeouts := make(map[string]time.Duration, len(ms))
+	for route, v := range ms {
+		if streamingRoutes[route] {
+			logrus.Warnf("ignoring timeout for streaming route %s", route)
+			continue
+		}
+		timeouts[route] = time.Duration(v) * time.Millisecond
+	}
+
+	timeoutsMu.Lock()
+	routeTimeouts = timeouts
+	timeoutsMu.Unlock()
+}
+
+// routeTemplate returns the path template of the request's route, or "" if
+// no route matched
+func routeTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return tpl
+}
+
+// routeTimeout returns the deadline configured for the request's route
+func routeTimeout(r *http.Request) (string, time.Duration) {
+	tpl := routeTemplate(r)
+	if tpl == "" {
+		return "", 0
+	}
+
+	timeoutsMu.RLock()
+	defer timeoutsMu.RUnlock()
+	return tpl, routeTimeouts[tpl]
+}
+
+// bufferedResponse collects a handler's response so it can be discarded on timeout
+type bufferedResponse struct {
+	header http.Header
+	body   bytes.Buffer
+	code   int
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.code == 0 {
+		b.code = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+// writeTo sends the collected response to w
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.code == 0 {
+		b.code = http.StatusOK
+	}
+	w.WriteHeader(b.code)
+	w.Write(b.body.Bytes())
+}
+
+// timeoutMiddleware enforces the configured per-route deadline, answering 504
+// with a structured error if the handler does not finish in time. The handler
+// runs on its own goroutine, so a panic in it is recovered here and answered
+// with 500 rather than crashing the server. After a 504 the request still
+// waits for the handler, whose context is cancelled, so it keeps its place
+// in the concurrency limits until the work really stops; a write may still
+// have been applied.
+func timeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, timeout := routeTimeout(r)
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		if unbufferedRoutes[route] {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		buf := &bufferedResponse{header: make(http.Header)}
+		done := make(chan struct{})
+		var panicked interface{}
+		go func() {
+			defer close(done)
+			defer func() {
+				if p := recover(); p != nil {
+					panicked = p
+					if p != http.ErrAbortHandler {
+						logrus.Errorf("panic serving %s: %v\n%s", r.URL.Path, p, debug.Stack())
+					}
+				}
+			}()
+			next.ServeHTTP(buf, r.WithContext(ctx))
+		}()
+
+		select {
+		case <-done:
+			if panicked == http.ErrAbortHandler {
+				panic(http.ErrAbortHandler)
+			}
+			if panicked != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			buf.writeTo(w)
+		case <-ctx.Done():
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGatewayTimeout)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":   "timeout",
+				"route":   route,
+				"timeout": timeout.String(),
+			})
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			<-done
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ValueTransform converts values on their way into the cache and back out
+type ValueTransform interface {
+	Encode(value string) (string, error)
+	Decode(value string) (string, error)
+}
+
+// trimTransform normalizes values by trimming surrounding whitespace
+type trimTransform struct{}
+
+func (trimTransform) Encode(value string) (string, error) { return strings.TrimSpace(value), nil }
+
+func (trimTransform) Decode(value string) (string, error) { return value, nil }
+
+// gzipTransform stores values gzip-compressed
+type gzipTransform struct{}
+
+func (gzipTransform) Encode(value string) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(value)); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (gzipTransform) Decode(value string) (string, error) {
+	zr, err := gzip.NewReader(strings.NewReader(value))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// aesTransform encrypts values with AES-GCM, prefixing each with its nonce
+type aesTransform struct {
+	aead cipher.AEAD
+}
+
+// newAESTransform creates an AES-GCM transform from a 16, 24 or 32 byte key
+func newAESTransform(key []byte) (*aesTransform, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aesTransform{aead: aead}, nil
+}
+
+func (t *aesTransform) Encode(value string) (string, error) {
+	nonce := make([]byte, t.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return string(t.aead.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+func (t *aesTransform) Decode(value string) (string, error) {
+	n := t.aead.NonceSize()
+	if len(value) < n {
+		return "", errors.New("ciphertext too short")
+	}
+	out, err := t.aead.Open(nil, []byte(value[:n]), []byte(value[n:]), nil)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+var encryptionKeys = map[string][]byte{} // Named AES keys for "aes:<name>" transforms
+
+// loadEncryptionKeys resolves the named keys for "aes:<name>" transforms
+func loadEncryptionKeys(specs map[string]string) error {
+	for name, spec := range specs {
+		key, err := resolveKey(spec)
+		if err != nil {
+			return fmt.Errorf("encryption key %q: %v", name, err)
+		}
+		encryptionKeys[name] = key
+	}
+	return nil
+}
+
+// resolveKey reads a key given as base64, "env:VAR" holding base64, or
+// "file:/path" holding raw key bytes
+func resolveKey(spec string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(spec, "env:"):
+		return base64.StdEncoding.DecodeString(os.Getenv(strings.TrimPrefix(spec, "env:")))
+	case strings.HasPrefix(spec, "file:"):
+		return os.ReadFile(strings.TrimPrefix(spec, "file:"))
+	default:
+		return base64.StdEncoding.DecodeString(spec)
+	}
+}
+
+// newTransform returns the built-in transform with the given name
+func newTransform(name string) (ValueTransform, error) {
+	switch name {
+	case "trim":
+		return trimTransform{}, nil
+	case "gzip":
+		return gzipTransform{}, nil
+	}
+	if keyName := strings.TrimPrefix(name, "aes:"); keyName != name {
+		key, ok := encryptionKeys[keyName]
+		if !ok {
+			return nil, fmt.Errorf("unknown encryption key %q", keyName)
+		}
+		return newAESTransform(key)
+	}
+	return nil, fmt.Errorf("unknown transform %q", name)
+}
+
+// transformRegistry maps key prefixes to the transform chain applied to their values
+type transformRegistry struct {
+	mu     sync.RWMutex
+	chains map[string][]ValueTransform
+}
+
+var transforms = &transformRegistry{chains: make(map[string][]ValueTransform)}
+
+// register sets the chain of named transforms for prefix, applied in order
+// on write. The prefix is canonicalized like the keys it must match.
+func (r *transformRegistry) register(prefix string, names []string) error {
+	prefix, err := canonicalPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	chain := make([]ValueTransform, 0, len(names))
+	for _, name := range names {
+		t, err := newTransform(name)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, t)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.chains[prefix] = chain
+	return nil
+}
+
+// chainFor returns the chain of the longest prefix matching key
+func (r *transformRegistry) chainFor(key string) []ValueTransform {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var chain []ValueTransform
+	longest := -1
+	for prefix, c := range r.chains {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			chain, longest = c, len(prefix)
+		}
+	}
+	return chain
+}
+
+// has reports whether values of key are transformed
+func (r *transformRegistry) has(key string) bool {
+	return len(r.chainFor(key)) > 0
+}
+
+// encode applies the chain for key to a value being written
+func (r *transformRegistry) encode(key, value string) (string, error) {
+	var err error
+	for _, t := range r.chainFor(key) {
+		if value, err = t.Encode(value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// decode reverses the chain for key on a value being read
+func (r *transformRegistry) decode(key, value string) (string, error) {
+	chain := r.chainFor(key)
+	var err error
+	for i := len(chain) - 1; i >= 0; i-- {
+		if value, err = chain[i].Decode(value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TTL is a time-to-live in a request: a number of seconds, which may be
+// fractional, or a Go duration string such as "90s" or "1h30m". null is the
+// same as leaving it out.
+type TTL time.Duration
+
+// ttlError reports a TTL that could not be parsed
+type ttlError struct {
+	value string
+}
+
+func (e *ttlError) Error() string {
+	return fmt.Sprintf("Invalid TTL %s: use seconds, e.g. 1.5, or a duration, e.g. \"90s\" or \"1h30m\"", e.value)
+}
+
+// parseTTL parses a TTL given as seconds or as a duration string
+func parseTTL(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(secs) || math.IsInf(secs, 0) || math.Abs(secs) > math.MaxInt64/float64(time.Second) {
+			return 0, &ttlError{strconv.Quote(s)}
+		}
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, &ttlError{strconv.Quote(s)}
+	}
+	return d, nil
+}
+
+func (t *TTL) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return &ttlError{string(data)}
+		}
+		s = n.String()
+	}
+	d, err := parseTTL(s)
+	if err != nil {
+		return err
+	}
+	*t = TTL(d)
+	return nil
+}
+
+// MarshalJSON encodes the TTL as seconds, which UnmarshalJSON accepts back
+func (t TTL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(t).Seconds())
+}
+
+// writeDecodeError answers 400 for a request body that failed to decode,
+// explaining TTL errors
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var te *ttlError
+	if errors.As(err, &te) {
+		http.Error(w, te.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
+}
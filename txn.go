@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrTxnConditionFailed is returned when a transaction check does not hold
+var ErrTxnConditionFailed = errors.New("transaction condition failed")
+
+// TxnCheck is a condition on one key. Exists, if set, requires the key to
+// be present or absent; Token, if non-zero, requires the value to have been
+// stored by that write; Match, if set, must accept the stored value.
+type TxnCheck struct {
+	Key    string
+	Exists *bool
+	Token  uint64
+	Match  func(value string) bool
+}
+
+// TxnOp is a write applied by a transaction: a set, or a delete when Delete is true
+type TxnOp struct {
+	Key    string
+	Value  string
+	Exp    time.Duration
+	Delete bool
+}
+
+// Txn applies ops only if every check holds, all under a single lock so no
+// other operation sees or interleaves with a partial result. It returns the
+// token of the last write, or ErrTxnConditionFailed with the index of the
+// first failing check, or ErrValueTooLarge if a set is oversized, in which
+// case nothing is applied.
+func (c *LRUCache) Txn(checks []TxnCheck, ops []TxnOp) (token uint64, failed int, err error) {
+	c.lock()
+	defer c.unlock()
+	defer func(start time.Time) {
+		keys := make([]string, len(ops))
+		for i, op := range ops {
+			keys[i] = op.Key
+		}
+		c.observe(OpSet, start, keys...)
+	}(time.Now())
+
+	now := c.clock.Now()
+	for i, check := range checks {
+		var item *CacheItem
+		if ele, ok := c.items[check.Key]; ok && !ele.Value.(*CacheItem).expired(now) {
+			item = ele.Value.(*CacheItem)
+		}
+		switch {
+		case check.Exists != nil && *check.Exists != (item != nil),
+			check.Token != 0 && (item == nil || item.written != check.Token),
+			check.Match != nil && (item == nil || !check.Match(item.Value)):
+			return 0, i, ErrTxnConditionFailed
+		}
+	}
+	for _, op := range ops {
+		if !op.Delete && c.tooLarge(op.Value) {
+			return 0, -1, ErrValueTooLarge
+		}
+	}
+
+	for _, op := range ops {
+		if !op.Delete {
+			c.set(op.Key, op.Value, op.Exp, entryOptions{})
+			continue
+		}
+		if ele, ok := c.items[op.Key]; ok {
+			c.removeElement(ele, RemovalDeleted)
+			c.token++
+		}
+	}
+	return c.token, -1, nil
+}
+
+// handleTxn handles the HTTP POST request applying a small all-or-nothing
+// update: every check must hold, then every op is applied atomically.
+// Checks compare the decoded value and the X-Cache-Token of a /get; a
+// failing check answers 409 with its index.
+func handleTxn(w http.ResponseWriter, r *http.Request) {
+	type TxnCheckRequest struct {
+		Key    string  `json:"key"`
+		Exists *bool   `json:"exists"`
+		Value  *string `json:"value"`
+		Token  uint64  `json:"token"`
+	}
+	type TxnOpRequest struct {
+		Op    string `json:"op"` // set or delete
+		Key   string `json:"key"`
+		Value string `json:"value"`
+		Exp   TTL    `json:"exp"` // Omitted uses the default TTL, negative never expires
+	}
+	type TxnRequest struct {
+		Checks []TxnCheckRequest `json:"checks"`
+		Ops    []TxnOpRequest    `json:"ops"`
+	}
+	type TxnResponse struct {
+		Succeeded bool   `json:"succeeded"`
+		Token     uint64 `json:"token,omitempty"`
+		Failed    *int   `json:"failed_check,omitempty"` // Index of the check that did not hold
+	}
+
+	var req TxnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if len(req.Ops) == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	checks := make([]TxnCheck, len(req.Checks))
+	for i, rc := range req.Checks {
+		if !canonicalizeKeys(w, &rc.Key) {
+			return
+		}
+		if !keyAllowed(r, rc.Key, AccessRead) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		checks[i] = TxnCheck{Key: rc.Key, Exists: rc.Exists, Token: rc.Token}
+		if rc.Value != nil {
+			key, expected := rc.Key, *rc.Value
+			// Stored values may be encrypted with a random nonce, so compare decoded
+			checks[i].Match = func(stored string) bool {
+				value, err := transforms.decode(key, stored)
+				return err == nil && value == expected
+			}
+		}
+	}
+
+	ops := make([]TxnOp, len(req.Ops))
+	for i, ro := range req.Ops {
+		if !canonicalizeKeys(w, &ro.Key) {
+			return
+		}
+		if !keyAllowed(r, ro.Key, AccessWrite) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		switch ro.Op {
+		case "delete":
+			ops[i] = TxnOp{Key: ro.Key, Delete: true}
+		case "set":
+			if errs := schemas.validate(ro.Key, ro.Value); len(errs) > 0 {
+				writeSchemaErrors(w, errs)
+				return
+			}
+			stored, err := transforms.encode(ro.Key, ro.Value)
+			if err != nil {
+				http.Error(w, "Value transform failed", http.StatusInternalServerError)
+				return
+			}
+			ops[i] = TxnOp{Key: ro.Key, Value: stored, Exp: time.Duration(ro.Exp)}
+		default:
+			http.Error(w, "Invalid op: "+ro.Op, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !admitWrite(w) {
+		return
+	}
+
+	token, failed, err := cache.Txn(checks, ops)
+	switch {
+	case errors.Is(err, ErrValueTooLarge):
+		writePressureHeaders(w)
+		http.Error(w, "Value too large", http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, ErrTxnConditionFailed):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(TxnResponse{Failed: &failed})
+		return
+	}
+
+	json.NewEncoder(w).Encode(TxnResponse{Succeeded: true, Token: token})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// upgradeSocketEnv tells a newly started binary where to receive the cache from
+const upgradeSocketEnv = "LRUCACHE_UPGRADE_SOCKET"
+
+// upgradeTimeout bounds how long the old process waits for the new one to connect
+const upgradeTimeout = 30 * time.Second
+
+// upgrader performs hot restarts. On SIGUSR2 it starts the current binary
+// with the listening sockets passed in LISTEN_FDS, and once the new process
+// connects back over a UNIX socket it stops serving, drains its listeners and
+// streams the cache across. Pending connections wait in the shared sockets'
+// backlog meanwhile, so none are dropped and no writes are lost.
+type upgrader struct {
+	m    *listenerManager
+	done context.CancelFunc // Stops the listeners to start the handoff
+	conn net.Conn           // Connection to the new process once it is ready
+}
+
+// watch starts a hot restart every time the process receives SIGUSR2
+func (u *upgrader) watch(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR2)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			conn, err := u.start()
+			if err != nil {
+				logrus.Errorf("hot restart: %v", err)
+				continue
+			}
+			u.conn = conn
+			u.done()
+			return
+		}
+	}
+}
+
+// start launches the new process and waits for it to connect back
+func (u *upgrader) start() (net.Conn, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(os.TempDir(), "lrucache-upgrade-"+strconv.Itoa(os.Getpid())+".sock")
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range u.m.listeners {
+		hl, ok := l.(*httpListener)
+		if !ok {
+			continue
+		}
+		f, err := hl.file()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		names = append(names, hl.name)
+	}
+
+	env := append(os.Environ(),
+		upgradeSocketEnv+"="+path,
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+	)
+	proc, err := os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("hot restart: started pid %d", proc.Pid)
+
+	ln.(*net.UnixListener).SetDeadline(time.Now().Add(upgradeTimeout))
+	conn, err := ln.Accept()
+	if err != nil {
+		proc.Kill()
+		proc.Release()
+		return nil, err
+	}
+	proc.Release()
+	return conn, nil
+}
+
+// handoffRecord is one entry streamed to the new process in a hot restart,
+// carrying what the entry's exported fields leave out
+type handoffRecord struct {
+	CacheItem
+	Written      uint64   `json:"written,omitempty"`
+	CacheControl string   `json:"cache_control,omitempty"`
+	DependsOn    []string `json:"depends_on,omitempty"`
+}
+
+// handoff streams the cache to the new process if a hot restart is under
+// way; the listeners must have stopped so the cache no longer changes. The
+// header carries the write token so conditional reads keep working.
+func (u *upgrader) handoff(c *LRUCache) error {
+	if u.conn == nil {
+		return nil
+	}
+	defer u.conn.Close()
+
+	items, token := c.Snapshot()
+	header := newSnapshotHeader()
+	header.Token = token
+	enc := json.NewEncoder(u.conn)
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	for _, item := range items {
+		rec := handoffRecord{CacheItem: item, Written: item.written, CacheControl: item.cacheControl, DependsOn: item.dependsOn}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("hot restart: handed off %d items", len(items))
+	return nil
+}
+
+// receiveHandoff connects to the old process at path and restores the cache
+// it streams across. The old process stops serving once this connects, so
+// it must only be called when nothing else can stop startup.
+func receiveHandoff(path string, c *LRUCache) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	snap, err := newSnapshotReader(conn)
+	if err != nil {
+		return err
+	}
+	var items []CacheItem
+	for {
+		var rec handoffRecord
+		err := snap.next(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		rec.written, rec.cacheControl, rec.dependsOn = rec.Written, rec.CacheControl, rec.DependsOn
+		items = append(items, rec.CacheItem)
+	}
+	c.Restore(items, snap.Header.Token)
+	logrus.Infof("hot restart: restored %d items", len(items))
+	return nil
+}
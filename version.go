@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// When unset, the module version and VCS stamp recorded by the Go toolchain are used.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// BuildInfo identifies the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// String formats the build info for -version
+func (b BuildInfo) String() string {
+	s := "lrucache " + b.Version
+	if b.Commit != "" {
+		s += " (" + b.Commit
+		if b.BuildDate != "" {
+			s += ", " + b.BuildDate
+		}
+		s += ")"
+	}
+	return s + " " + b.GoVersion
+}
+
+// buildInfo returns the version stamped in with -ldflags, falling back to
+// the build info embedded by the Go toolchain
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: "(devel)", GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if bi.Main.Version != "" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Commit = s.Value
+			case "vcs.time":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	if version != "" {
+		info.Version = version
+	}
+	if commit != "" {
+		info.Commit = commit
+	}
+	if buildDate != "" {
+		info.BuildDate = buildDate
+	}
+	return info
+}
+
+// handleVersion handles the HTTP GET request reporting the build version
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(buildInfo())
+}